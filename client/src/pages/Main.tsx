@@ -50,6 +50,7 @@ import {
   useAddInstance,
   useConnect,
   useDisconnect,
+  useEvents,
   useGetConnection,
   useInstanceData,
   useInstanceReplication,
@@ -414,6 +415,8 @@ function Provision() {
 }
 
 function Main() {
+  useEvents();
+
   return (
     <Tabs defaultValue="provision" className="p-16">
       <TabsList className="mb-8">
@@ -1,5 +1,6 @@
 import queryClient from "@/client.ts";
 import { useMutation, useQuery } from "@tanstack/react-query";
+import { useEffect } from "react";
 import { toast } from "sonner";
 import { z } from "zod";
 
@@ -8,6 +9,7 @@ const instanceSchema = z.object({
   ContainerID: z.string(),
   NetworkID: z.string(),
   Port: z.string(),
+  State: z.string(),
 });
 
 export type InstanceSchema = z.infer<typeof instanceSchema>;
@@ -242,3 +244,16 @@ export function useDisconnect(name1: string, name2: string) {
     },
   });
 }
+
+// refreshes instances whenever the control plane changes something
+export function useEvents() {
+  useEffect(() => {
+    const source = new EventSource("/api/events");
+    source.onmessage = () => {
+      queryClient.invalidateQueries({
+        queryKey: ["instances"],
+      });
+    };
+    return () => source.close();
+  }, []);
+}
@@ -8,7 +8,10 @@ export default defineConfig({
   plugins: [react(), tailwindcss()],
   server: {
     proxy: {
-      "/api": "http://netpart-control",
+      "/api": {
+        target: "http://netpart-control",
+        ws: true,
+      },
     },
   },
   resolve: {
@@ -5,14 +5,16 @@ while ! docker version > /dev/null; do
     sleep 3
 done
 
-if [ -e "/images/$POSTGRES_IMAGE.tar" ]
-then
-    echo "Found image file, loading..."
-    for file in /images/*.tar; do
+for image in $POSTGRES_IMAGE $CAPTURE_IMAGE; do
+    # slashes in the image name would point into a directory
+    file="/images/$(echo $image | tr '/' '_').tar"
+    if [ -e "$file" ]
+    then
+        echo "Found image file for $image, loading..."
         docker load < $file
-    done
-else
-    echo "Did not find image file, pulling..."
-    docker image pull $POSTGRES_IMAGE
-    docker image save $POSTGRES_IMAGE > /images/$POSTGRES_IMAGE.tar
-fi
+    else
+        echo "Did not find image file for $image, pulling..."
+        docker image pull $image
+        docker image save $image > $file
+    fi
+done
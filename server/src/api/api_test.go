@@ -1,20 +1,24 @@
 package api_test
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"netpart/api"
+	"netpart/client"
 	"netpart/control"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
 )
 
 const SERVER = "127.0.0.1:7001"
-const BASE_URL = "http://" + SERVER + "/api"
+
+var cl = client.New("http://" + SERVER)
 
 func TestMain(m *testing.M) {
 	ctx := context.Background()
@@ -29,21 +33,7 @@ func TestMain(m *testing.M) {
 func TestListInstance(t *testing.T) {
 	ctx := context.Background()
 
-	var client http.Client
-	req, err := http.NewRequestWithContext(ctx, "GET", BASE_URL+"/instances", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	res, err := client.Do(req)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if res.StatusCode != http.StatusOK {
-		t.Fatalf("response not ok. got %v", res.StatusCode)
-	}
-
-	_, err = decode[api.ListInstanceResponse](res)
+	_, err := cl.ListInstances(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -56,53 +46,74 @@ func TestCreateAndDelete(t *testing.T) {
 	var inst control.Instance
 
 	t.Run("add instance", func(t *testing.T) {
-		inst, err = addRequest(ctx, "test")
+		inst, err = cl.AddInstance(ctx, api.AddInstanceBody{Name: "test"})
 		if err != nil {
 			t.Fatal(err)
 		}
 	})
 
+	t.Run("duplicate instance", func(t *testing.T) {
+		_, err := cl.AddInstance(ctx, api.AddInstanceBody{Name: "test"})
+		if !client.IsAlreadyExists(err) {
+			t.Fatalf("expected already exists, got %v", err)
+		}
+	})
+
+	t.Run("invalid name", func(t *testing.T) {
+		_, err := cl.AddInstance(ctx, api.AddInstanceBody{Name: "Test_1"})
+		if !client.IsInvalid(err) {
+			t.Fatalf("expected invalid, got %v", err)
+		}
+	})
+
 	t.Run("delete instance", func(t *testing.T) {
-		_, err = deleteRequest(ctx, inst.Name)
+		err = cl.KillInstance(ctx, inst.Name, false)
 		if err != nil {
 			t.Fatal(err)
 		}
 	})
+
+	t.Run("instance is gone", func(t *testing.T) {
+		_, err = cl.GetReplication(ctx, inst.Name)
+		if !client.IsNotFound(err) {
+			t.Fatalf("expected not found, got %v", err)
+		}
+	})
 }
 
 func TestConnectAndDisconnect(t *testing.T) {
 	ctx := context.Background()
 	var err error
 
-	inst1, err := addRequest(ctx, "test1")
+	inst1, err := cl.AddInstance(ctx, api.AddInstanceBody{Name: "test1"})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	inst2, err := addRequest(ctx, "test2")
+	inst2, err := cl.AddInstance(ctx, api.AddInstanceBody{Name: "test2"})
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	t.Run("connect instance", func(t *testing.T) {
-		_, err := connect(ctx, inst1.Name, inst2.Name)
+		err := cl.Connect(ctx, inst1.Name, inst2.Name)
 		if err != nil {
 			t.Fatal(err)
 		}
 	})
 
 	t.Run("test connect instance", func(t *testing.T) {
-		res, err := getConnect(ctx, inst1.Name, inst2.Name)
+		connected, err := cl.GetConnection(ctx, inst1.Name, inst2.Name)
 		if err != nil {
 			t.Fatal(err)
 		}
-		if !res.Connected {
+		if !connected {
 			t.Fatalf("node failed to connect!")
 		}
 	})
 
 	t.Run("disconnect instance", func(t *testing.T) {
-		_, err := disconnect(ctx, inst1.Name, inst2.Name)
+		err := cl.Disconnect(ctx, inst1.Name, inst2.Name)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -113,37 +124,37 @@ func TestPrimarySecondary(t *testing.T) {
 	ctx := context.Background()
 	var err error
 
-	inst1, err := addRequest(ctx, "test3")
+	inst1, err := cl.AddInstance(ctx, api.AddInstanceBody{Name: "test3"})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	inst2, err := addRequest(ctx, "test4")
+	inst2, err := cl.AddInstance(ctx, api.AddInstanceBody{Name: "test4"})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = connect(ctx, inst1.Name, inst2.Name)
+	err = cl.Connect(ctx, inst1.Name, inst2.Name)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	t.Run("setup primary", func(t *testing.T) {
-		_, err := modifyRequest(ctx, inst1.Name, api.ModifyInstanceBody{
+		err := cl.ModifyInstance(ctx, inst1.Name, api.ModifyInstanceBody{
 			Primary: true,
 		})
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		_, err = getReplicationRequest(ctx, inst1.Name)
+		_, err = cl.GetReplication(ctx, inst1.Name)
 		if err != nil {
 			t.Fatal(err)
 		}
 	})
 
 	t.Run("setup standby", func(t *testing.T) {
-		_, err := modifyRequest(ctx, inst2.Name, api.ModifyInstanceBody{
+		err := cl.ModifyInstance(ctx, inst2.Name, api.ModifyInstanceBody{
 			Standby:   true,
 			StandbyTo: inst1.Name,
 		})
@@ -151,14 +162,14 @@ func TestPrimarySecondary(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		_, err = getReplicationRequest(ctx, inst2.Name)
+		_, err = cl.GetReplication(ctx, inst2.Name)
 		if err != nil {
 			t.Fatal(err)
 		}
 	})
 
 	t.Run("enable standby", func(t *testing.T) {
-		_, err := modifyRequest(ctx, inst2.Name, api.ModifyInstanceBody{
+		err := cl.ModifyInstance(ctx, inst2.Name, api.ModifyInstanceBody{
 			Refresh:   true,
 			RefreshTo: inst1.Name,
 		})
@@ -172,342 +183,255 @@ func TestQueries(t *testing.T) {
 	ctx := context.Background()
 	var err error
 
-	inst, err := addRequest(ctx, "test5")
+	inst, err := cl.AddInstance(ctx, api.AddInstanceBody{Name: "test5"})
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	t.Run("get keys", func(t *testing.T) {
-		_, err := getKeysRequest(ctx, inst.Name)
+		_, err := cl.GetKeys(ctx, inst.Name)
 		if err != nil {
 			t.Fatal(err)
 		}
 	})
 
 	t.Run("put keys", func(t *testing.T) {
-		_, err := putKeysRequest(ctx, inst.Name, "key", "value")
+		err := cl.PutKey(ctx, inst.Name, "key", "value")
 		if err != nil {
 			t.Fatal(err)
 		}
 	})
 }
 
-func deleteRequest(ctx context.Context, name string) (api.KillInstanceResponse, error) {
-	var client http.Client
-	var resp api.KillInstanceResponse
+func TestEventStream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, "DELETE", BASE_URL+"/instances/"+name, nil)
-	if err != nil {
-		return resp, err
-	}
+	added := make(chan string, 16)
+	go cl.Events(ctx, 0, func(e control.Event) error {
+		if e.Type == control.EVENT_INSTANCE_ADDED {
+			added <- e.Instances[0]
+		}
+		return nil
+	})
 
-	res, err := client.Do(req)
-	if err != nil {
-		return resp, err
+	seen := func(name string) bool {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case got := <-added:
+				if got == name {
+					return true
+				}
+			case <-timeout:
+				return false
+			case <-ctx.Done():
+				return false
+			}
+		}
 	}
-	if res.StatusCode != http.StatusOK {
-		val, err := decode[api.KillInstanceResponse](res)
+
+	// the stream may not be open yet when the first instance is added, a missed event means another try
+	for i := range 3 {
+		inst, err := cl.AddInstance(ctx, api.AddInstanceBody{Name: fmt.Sprintf("test7-%v", i)})
 		if err != nil {
-			return resp, fmt.Errorf("response not ok. got %v. cant parse: %w", res.StatusCode, err)
-		} else {
-			return resp, fmt.Errorf("response not ok. got %v. %v", res.StatusCode, val.Message)
+			t.Fatal(err)
 		}
-	}
 
-	val, err := decode[api.KillInstanceResponse](res)
-	if err != nil {
-		return resp, err
+		if seen(inst.Name) {
+			return
+		}
 	}
-	return val, nil
-}
-
-func modifyRequest(ctx context.Context, name string, body api.ModifyInstanceBody) (api.ModifyInstanceResponse, error) {
-	var client http.Client
-	var resp api.ModifyInstanceResponse
 
-	reader, err := encode(body)
-	if err != nil {
-		return resp, err
-	}
+	t.Fatal("no event for the added instances")
+}
 
-	req, err := http.NewRequestWithContext(ctx, "PUT", BASE_URL+"/instances/"+name, reader)
-	if err != nil {
-		return resp, err
-	}
+func TestOpenAPI(t *testing.T) {
+	ctx := context.Background()
 
-	res, err := client.Do(req)
-	if err != nil {
-		return resp, err
-	}
-	if res.StatusCode != http.StatusOK {
-		val, err := decode[api.ModifyInstanceResponse](res)
+	t.Run("serves the document", func(t *testing.T) {
+		doc, err := openapi3.NewLoader().LoadFromURI(&url.URL{Scheme: "http", Host: SERVER, Path: "/api/openapi.json"})
 		if err != nil {
-			return resp, fmt.Errorf("response not ok. got %v. cant parse: %w", res.StatusCode, err)
-		} else {
-			return resp, fmt.Errorf("response not ok. got %v. %v", res.StatusCode, val.Message)
+			t.Fatal(err)
 		}
-	}
-
-	val, err := decode[api.ModifyInstanceResponse](res)
-	if err != nil {
-		return resp, err
-	}
-	return val, nil
-}
 
-func getReplicationRequest(ctx context.Context, name string) (api.GetInstanceReplicationSuccess, error) {
-	var client http.Client
-	var resp api.GetInstanceReplicationSuccess
+		err = doc.Validate(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", BASE_URL+"/instances/"+name, nil)
-	if err != nil {
-		return resp, err
-	}
+		if doc.Paths.Find("/instances/{name}").GetOperation("PUT") == nil {
+			t.Fatalf("expected the instance routes in the document")
+		}
+	})
 
-	res, err := client.Do(req)
-	if err != nil {
-		return resp, err
-	}
-	if res.StatusCode != http.StatusOK {
-		val, err := decode[api.GetInstanceReplicationFail](res)
+	t.Run("rejects invalid bodies", func(t *testing.T) {
+		req, err := http.NewRequestWithContext(ctx, "POST", "http://"+SERVER+"/api/instances", strings.NewReader(`{"Name":5}`))
 		if err != nil {
-			return resp, fmt.Errorf("response not ok. got %v. cant parse: %w", res.StatusCode, err)
-		} else {
-			return resp, fmt.Errorf("response not ok. got %v. %v", res.StatusCode, val.Message)
+			t.Fatal(err)
 		}
-	}
 
-	val, err := decode[api.GetInstanceReplicationSuccess](res)
-	if err != nil {
-		return resp, err
-	}
-	return val, nil
-}
-
-func getKeysRequest(ctx context.Context, name string) (api.GetKeysSuccessResponse, error) {
-	var client http.Client
-	var resp api.GetKeysSuccessResponse
+		var hc http.Client
+		res, err := hc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", BASE_URL+"/instances/"+name+"/keys", nil)
-	if err != nil {
-		return resp, err
-	}
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected bad request, got %v", res.StatusCode)
+		}
+	})
 
-	res, err := client.Do(req)
-	if err != nil {
-		return resp, err
-	}
-	if res.StatusCode != http.StatusOK {
-		val, err := decode[api.GetKeysFailResponse](res)
+	t.Run("rejects keys that only differ by case", func(t *testing.T) {
+		req, err := http.NewRequestWithContext(ctx, "POST", "http://"+SERVER+"/api/instances", strings.NewReader(`{"name":"test10","Name":"test11"}`))
 		if err != nil {
-			return resp, fmt.Errorf("response not ok. got %v. cant parse: %w", res.StatusCode, err)
-		} else {
-			return resp, fmt.Errorf("response not ok. got %v. %v", res.StatusCode, val.Message)
+			t.Fatal(err)
 		}
-	}
-
-	val, err := decode[api.GetKeysSuccessResponse](res)
-	if err != nil {
-		return resp, err
-	}
-	return val, nil
-}
 
-func putKeysRequest(ctx context.Context, name string, key string, value string) (api.PutKeysResponse, error) {
-	var client http.Client
-	var resp api.PutKeysResponse
+		var hc http.Client
+		res, err := hc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
 
-	body, err := encode(api.PutKeysBody{
-		Value: value,
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected bad request, got %v", res.StatusCode)
+		}
 	})
 
-	if err != nil {
-		return resp, err
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "PUT", BASE_URL+"/instances/"+name+"/keys/"+key, body)
-	if err != nil {
-		return resp, err
-	}
-
-	res, err := client.Do(req)
-	if err != nil {
-		return resp, err
-	}
-	if res.StatusCode != http.StatusOK {
-		val, err := decode[api.PutKeysResponse](res)
+	t.Run("accepts keys in any case", func(t *testing.T) {
+		// what the json decoder has always taken, and what the web client sends
+		req, err := http.NewRequestWithContext(ctx, "POST", "http://"+SERVER+"/api/instances", strings.NewReader(`{"name":"test8"}`))
 		if err != nil {
-			return resp, fmt.Errorf("response not ok. got %v. cant parse: %w", res.StatusCode, err)
-		} else {
-			return resp, fmt.Errorf("response not ok. got %v. %v", res.StatusCode, val.Message)
+			t.Fatal(err)
 		}
-	}
 
-	val, err := decode[api.PutKeysResponse](res)
-	if err != nil {
-		return resp, err
-	}
-	return val, nil
-}
+		var hc http.Client
+		res, err := hc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
 
-func addRequest(ctx context.Context, name string) (api.AddInstanceSuccessResponse, error) {
-	var client http.Client
-	var resp api.AddInstanceSuccessResponse
-	body, err := encode(api.AddInstanceBody{
-		Name: name,
-	})
-	if err != nil {
-		return resp, err
-	}
-	req, err := http.NewRequestWithContext(ctx, "POST", BASE_URL+"/instances", body)
-	if err != nil {
-		return resp, err
-	}
+		if res.StatusCode/100 != 2 {
+			body, _ := io.ReadAll(res.Body)
+			t.Fatalf("expected success, got %v: %s", res.StatusCode, body)
+		}
 
-	res, err := client.Do(req)
-	if err != nil {
-		return resp, err
-	}
-	if res.StatusCode != http.StatusOK {
-		val, err := decode[api.AddInstanceErrorResponse](res)
+		err = cl.KillInstance(ctx, control.PREFIX+"test8", false)
 		if err != nil {
-			return resp, fmt.Errorf("response not ok. got %v. cant parse: %w", res.StatusCode, err)
-		} else {
-			return resp, fmt.Errorf("response not ok. got %v. %v", res.StatusCode, val.Message)
+			t.Fatal(err)
 		}
-	}
-
-	val, err := decode[api.AddInstanceSuccessResponse](res)
-	if err != nil {
-		return resp, err
-	}
-	return val, nil
+	})
 }
 
-func connect(ctx context.Context, name1 string, name2 string) (api.ConnectResponse, error) {
-	var client http.Client
-	var resp api.ConnectResponse
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
 
-	url := fmt.Sprintf(BASE_URL+"/instances/%v/connections/%v", name1, name2)
-	req, err := http.NewRequestWithContext(ctx, "PUT", url, nil)
+	inst, err := cl.AddInstance(ctx, api.AddInstanceBody{Name: "test6"})
 	if err != nil {
-		return resp, err
+		t.Fatal(err)
 	}
 
-	res, err := client.Do(req)
+	err = cl.PutKey(ctx, inst.Name, "key", "value")
 	if err != nil {
-		return resp, err
-	}
-	if res.StatusCode != http.StatusOK {
-		val, err := decode[api.AddInstanceErrorResponse](res)
-		if err != nil {
-			return resp, fmt.Errorf("response not ok. got %v. cant parse: %w", res.StatusCode, err)
-		} else {
-			return resp, fmt.Errorf("response not ok. got %v. %v", res.StatusCode, val.Message)
-		}
+		t.Fatal(err)
 	}
 
-	val, err := decode[api.ConnectResponse](res)
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+SERVER+"/metrics", nil)
 	if err != nil {
-		return resp, err
+		t.Fatal(err)
 	}
-	return val, nil
-}
-
-func disconnect(ctx context.Context, name1 string, name2 string) (api.DisconnectResponse, error) {
-	var client http.Client
-	var resp api.DisconnectResponse
 
-	url := fmt.Sprintf(BASE_URL+"/instances/%v/connections/%v", name1, name2)
-	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
+	var hc http.Client
+	res, err := hc.Do(req)
 	if err != nil {
-		return resp, err
+		t.Fatal(err)
 	}
+	defer res.Body.Close()
 
-	res, err := client.Do(req)
+	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		return resp, err
-	}
-	if res.StatusCode != http.StatusOK {
-		val, err := decode[api.DisconnectResponse](res)
-		if err != nil {
-			return resp, fmt.Errorf("response not ok. got %v. cant parse: %w", res.StatusCode, err)
-		} else {
-			return resp, fmt.Errorf("response not ok. got %v. %v", res.StatusCode, val.Message)
-		}
+		t.Fatal(err)
 	}
 
-	val, err := decode[api.DisconnectResponse](res)
-	if err != nil {
-		return resp, err
+	for _, name := range []string{
+		"netpart_instances",
+		"netpart_kv_duration_seconds",
+		"netpart_docker_request_duration_seconds",
+	} {
+		if !strings.Contains(string(body), name) {
+			t.Fatalf("expected %v in the metrics", name)
+		}
 	}
-	return val, nil
 }
 
-func getConnect(ctx context.Context, name1 string, name2 string) (api.GetConnectResponse, error) {
-	var client http.Client
-	var resp api.GetConnectResponse
+func TestScrapeDuringAdd(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a scrape lists the instances, which are half made for a while during an add or a kill
+	scrapeErrs := make(chan error, 1)
+	go func() {
+		defer close(scrapeErrs)
+
+		var hc http.Client
+		for ctx.Err() == nil {
+			req, err := http.NewRequestWithContext(ctx, "GET", "http://"+SERVER+"/metrics", nil)
+			if err != nil {
+				scrapeErrs <- err
+				return
+			}
+
+			res, err := hc.Do(req)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				scrapeErrs <- err
+				return
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+
+			if res.StatusCode != http.StatusOK {
+				scrapeErrs <- fmt.Errorf("scrape answered %v", res.StatusCode)
+				return
+			}
+		}
+	}()
 
-	url := fmt.Sprintf(BASE_URL+"/instances/%v/connections/%v", name1, name2)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return resp, err
-	}
+	for i := range 3 {
+		inst, err := cl.AddInstance(ctx, api.AddInstanceBody{Name: fmt.Sprintf("test9-%v", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
 
-	res, err := client.Do(req)
-	if err != nil {
-		return resp, err
-	}
-	if res.StatusCode != http.StatusOK {
-		val, err := decode[api.GetConnectResponse](res)
+		err = cl.KillInstance(ctx, inst.Name, false)
 		if err != nil {
-			return resp, fmt.Errorf("response not ok. got %v. cant parse: %w", res.StatusCode, err)
-		} else {
-			return resp, fmt.Errorf("response not ok. got %v. %v", res.StatusCode, val.Message)
+			t.Fatal(err)
 		}
 	}
 
-	val, err := decode[api.GetConnectResponse](res)
+	cancel()
+	err := <-scrapeErrs
 	if err != nil {
-		return resp, err
+		t.Fatal(err)
 	}
-	return val, nil
 }
 
 func wait(ctx context.Context) error {
 	for {
-		var client http.Client
-		req, err := http.NewRequestWithContext(ctx, "GET", BASE_URL+"/ping", nil)
-		if err != nil {
-			return err
-		}
-		res, err := client.Do(req)
-		if err != nil {
-			time.Sleep(250 * time.Millisecond)
-			continue
-		}
-		defer res.Body.Close()
-
-		if res.StatusCode == http.StatusOK {
+		err := cl.Ping(ctx)
+		if err == nil {
 			return nil
 		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
 		time.Sleep(250 * time.Millisecond)
 	}
 }
-
-func decode[T any](r *http.Response) (T, error) {
-	var v T
-	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
-		return v, fmt.Errorf("decode json: %w", err)
-	}
-	return v, nil
-}
-
-func encode[T any](v T) (io.Reader, error) {
-	data, err := json.Marshal(v)
-	if err != nil {
-		return nil, err
-	}
-	return bytes.NewReader(data), nil
-}
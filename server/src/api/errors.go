@@ -0,0 +1,59 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"netpart/control"
+)
+
+// Machine-readable codes of ErrorResponse.
+const (
+	CODE_INVALID        = "invalid"
+	CODE_NOT_FOUND      = "not_found"
+	CODE_ALREADY_EXISTS = "already_exists"
+	CODE_CONFLICT       = "conflict"
+	CODE_UNREACHABLE    = "unreachable"
+	CODE_TIMEOUT        = "timeout"
+	CODE_INTERNAL       = "internal"
+)
+
+var CODES = []string{CODE_INVALID, CODE_NOT_FOUND, CODE_ALREADY_EXISTS, CODE_CONFLICT, CODE_UNREACHABLE, CODE_TIMEOUT, CODE_INTERNAL}
+
+// Every failed request is answered with this.
+type ErrorResponse struct {
+	Code    string `openapi:"required"`
+	Message string `openapi:"required"`
+}
+
+var errorStatus = []struct {
+	kind   error
+	status int
+	code   string
+}{
+	{control.ErrInvalid, http.StatusBadRequest, CODE_INVALID},
+	{control.ErrNotFound, http.StatusNotFound, CODE_NOT_FOUND},
+	{control.ErrAlreadyExists, http.StatusConflict, CODE_ALREADY_EXISTS},
+	{control.ErrConflict, http.StatusConflict, CODE_CONFLICT},
+	{control.ErrUnreachable, http.StatusServiceUnavailable, CODE_UNREACHABLE},
+	{control.ErrTimeout, http.StatusGatewayTimeout, CODE_TIMEOUT},
+}
+
+// Answers with the status matching the kind of err, or 500 when it has none.
+func fail(w http.ResponseWriter, r *http.Request, err error) {
+	kind := control.KindOf(err)
+	for _, e := range errorStatus {
+		if errors.Is(kind, e.kind) {
+			encode(w, r, e.status, ErrorResponse{Code: e.code, Message: err.Error()})
+			return
+		}
+	}
+
+	slog.ErrorContext(r.Context(), "request failed", "err", err)
+	encode(w, r, http.StatusInternalServerError, ErrorResponse{Code: CODE_INTERNAL, Message: err.Error()})
+}
+
+// For requests that are wrong before they reach the control plane.
+func badRequest(w http.ResponseWriter, r *http.Request, message string) {
+	encode(w, r, http.StatusBadRequest, ErrorResponse{Code: CODE_INVALID, Message: message})
+}
@@ -1,19 +1,24 @@
 package api
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"netpart/control"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 )
 
 type AddInstanceBody struct {
-	Name string
-}
-
-type AddInstanceErrorResponse struct {
-	Message string
+	Name       string `openapi:"required"`
+	Parameters map[string]string
+	Resources  control.Resources
 }
 
 type AddInstanceSuccessResponse = control.Instance
@@ -21,25 +26,37 @@ type AddInstanceSuccessResponse = control.Instance
 func addInstanceHandler(c *control.ControlPlane, image string) http.Handler {
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		var resp AddInstanceErrorResponse
 
 		body, err := decode[AddInstanceBody](r)
 		if err != nil {
-			resp.Message = "cannot decode request"
-			encode(w, r, http.StatusBadRequest, resp)
+			badRequest(w, r, "cannot decode request")
 			return
 		}
 
-		if body.Name == "" {
-			resp.Message = "invalid instance name"
-			encode(w, r, http.StatusBadRequest, resp)
+		err = control.ValidateName(body.Name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		err = control.ValidateParameters(body.Parameters)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		err = control.ValidateResources(body.Resources)
+		if err != nil {
+			fail(w, r, err)
 			return
 		}
 
-		inst, err := c.AddInstance(ctx, body.Name, image)
+		inst, err := c.AddInstance(ctx, body.Name, image,
+			control.WithParameters(body.Parameters),
+			control.WithResources(body.Resources),
+		)
 		if err != nil {
-			resp.Message = err.Error()
-			encode(w, r, http.StatusInternalServerError, resp)
+			fail(w, r, err)
 			return
 		}
 
@@ -53,12 +70,10 @@ type ListInstanceResponse = []control.Instance
 func listInstanceHandler(c *control.ControlPlane) http.Handler {
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		var resp AddInstanceErrorResponse
 
 		insts, err := c.ListInstances(ctx)
 		if err != nil {
-			resp.Message = err.Error()
-			encode(w, r, http.StatusInternalServerError, resp)
+			fail(w, r, err)
 			return
 		}
 		encode(w, r, http.StatusOK, insts)
@@ -71,9 +86,39 @@ type ModifyInstanceBody struct {
 
 	Standby   bool
 	StandbyTo string
+	SkipCopy  bool // for standbys that already have the primary's data
+
+	StandbyPublications []string // defaults to every publication of the schema
+	Republish           bool     // lets other standbys subscribe to this one
 
 	Refresh   bool
 	RefreshTo string
+
+	Disable     bool
+	DisableFrom string
+
+	// also drops the slot on the primary
+	Drop     bool
+	DropFrom string
+
+	// moves the standby over to a new primary
+	Repoint     bool
+	RepointFrom string
+	RepointTo   string
+
+	// picks up tables added to the primary's publication
+	RefreshPublication     bool
+	RefreshPublicationFrom string
+}
+
+func (b ModifyInstanceBody) actions() int {
+	count := 0
+	for _, set := range []bool{b.Primary, b.Standby, b.Refresh, b.Disable, b.Drop, b.Repoint, b.RefreshPublication} {
+		if set {
+			count++
+		}
+	}
+	return count
 }
 
 type ModifyInstanceResponse struct {
@@ -88,43 +133,103 @@ func modifyInstanceHandler(c *control.ControlPlane) http.Handler {
 		name := mux.Vars(r)["name"]
 		inst, err := c.GetInstance(ctx, name)
 		if err != nil {
-			resp.Message = fmt.Sprintf("could not find instance %v", name)
-			encode(w, r, http.StatusNotFound, resp)
+			fail(w, r, err)
 			return
 		}
 
 		body, err := decode[ModifyInstanceBody](r)
+		if err != nil {
+			badRequest(w, r, "cannot decode request")
+			return
+		}
+
 		if body.Primary && body.Standby {
-			resp.Message = "cannot set a node as primary and secondary"
-			encode(w, r, http.StatusBadRequest, resp)
+			badRequest(w, r, "cannot set a node as primary and secondary")
+			return
+		}
+
+		if body.actions() > 1 {
+			badRequest(w, r, "can only do one modification at a time")
 			return
 		}
 
 		if body.Primary {
-			err = control.SetupPrimary(ctx, inst)
+			err = c.SetupPrimary(ctx, inst, c.Schema())
 		} else if body.Standby {
 			var primary control.Instance
 			primary, err = c.GetInstance(ctx, body.StandbyTo)
 			if err != nil {
-				resp.Message = fmt.Sprintf("unable to find primary %v", primary)
-				encode(w, r, http.StatusBadRequest, resp)
+				fail(w, r, err)
+				return
+			}
+			err = c.CheckLoop(ctx, inst, primary)
+			if err != nil {
+				fail(w, r, err)
 				return
 			}
-			err = control.SetupStandby(ctx, inst, primary)
+			schema := c.Schema()
+			publications := body.StandbyPublications
+			if len(publications) == 0 {
+				publications = schema.PublicationNames()
+			}
+			opts := []control.StandbyOption{control.WithPublications(publications...)}
+			if body.SkipCopy {
+				opts = append(opts, control.WithoutCopy())
+			}
+			err = c.SetupStandby(ctx, inst, primary, opts...)
+			if err == nil && body.Republish {
+				err = c.Republish(ctx, inst, schema)
+			}
 		} else if body.Refresh {
 			var primary control.Instance
 			primary, err = c.GetInstance(ctx, body.RefreshTo)
 			if err != nil {
-				resp.Message = fmt.Sprintf("unable to find primary %v", primary)
-				encode(w, r, http.StatusBadRequest, resp)
+				fail(w, r, err)
+				return
+			}
+			err = c.RestartStandby(ctx, inst, primary)
+		} else if body.Disable {
+			var primary control.Instance
+			primary, err = c.GetInstance(ctx, body.DisableFrom)
+			if err != nil {
+				fail(w, r, err)
+				return
+			}
+			err = c.DisableStandby(ctx, inst, primary)
+		} else if body.Drop {
+			var primary control.Instance
+			primary, err = c.GetInstance(ctx, body.DropFrom)
+			if err != nil {
+				fail(w, r, err)
+				return
+			}
+			err = c.DropStandby(ctx, inst, primary)
+		} else if body.Repoint {
+			var old_primary control.Instance
+			old_primary, err = c.GetInstance(ctx, body.RepointFrom)
+			if err != nil {
+				fail(w, r, err)
+				return
+			}
+			var primary control.Instance
+			primary, err = c.GetInstance(ctx, body.RepointTo)
+			if err != nil {
+				fail(w, r, err)
+				return
+			}
+			err = c.RepointStandby(ctx, inst, old_primary, primary)
+		} else if body.RefreshPublication {
+			var primary control.Instance
+			primary, err = c.GetInstance(ctx, body.RefreshPublicationFrom)
+			if err != nil {
+				fail(w, r, err)
 				return
 			}
-			err = control.RestartStandby(ctx, inst, primary)
+			err = control.RefreshStandby(ctx, inst, primary)
 		}
 
 		if err != nil {
-			resp.Message = err.Error()
-			encode(w, r, http.StatusInternalServerError, resp)
+			fail(w, r, err)
 			return
 		}
 
@@ -137,31 +242,23 @@ func modifyInstanceHandler(c *control.ControlPlane) http.Handler {
 
 type GetInstanceReplicationSuccess = control.ReplicationData
 
-type GetInstanceReplicationFail struct {
-	Message string
-}
-
 func getInstanceHandler(c *control.ControlPlane) http.Handler {
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		var resp GetInstanceReplicationFail
 
 		name := mux.Vars(r)["name"]
 		inst, err := c.GetInstance(ctx, name)
 		if err != nil {
-			resp.Message = fmt.Sprintf("could not find instance %v", name)
-			encode(w, r, http.StatusNotFound, resp)
+			fail(w, r, err)
 			return
 		}
 
 		data, err := control.GetReplicationData(ctx, inst)
 		if err != nil {
-			resp.Message = err.Error()
-			encode(w, r, http.StatusInternalServerError, resp)
+			fail(w, r, err)
 			return
 		}
 
-		resp.Message = "OK"
 		encode(w, r, http.StatusOK, data)
 	}
 
@@ -181,15 +278,16 @@ func killInstanceHandler(c *control.ControlPlane) http.Handler {
 		name := mux.Vars(r)["name"]
 		inst, err := c.GetInstance(ctx, name)
 		if err != nil {
-			resp.Message = fmt.Sprintf("could not find instance %v", name)
-			encode(w, r, http.StatusNotFound, resp)
+			fail(w, r, err)
 			return
 		}
 
-		err = c.KillInstance(ctx, inst)
+		// ?keep_volume=true lets a new instance with the same name reuse the data
+		keepVolume := r.URL.Query().Get("keep_volume") == "true"
+
+		err = c.KillInstance(ctx, inst, keepVolume)
 		if err != nil {
-			resp.Message = err.Error()
-			encode(w, r, http.StatusInternalServerError, resp)
+			fail(w, r, err)
 			return
 		}
 
@@ -213,23 +311,20 @@ func getConnectHandler(c *control.ControlPlane) http.Handler {
 		name1 := mux.Vars(r)["name1"]
 		inst1, err := c.GetInstance(ctx, name1)
 		if err != nil {
-			resp.Message = fmt.Sprintf("could not find instance %v", name1)
-			encode(w, r, http.StatusNotFound, resp)
+			fail(w, r, err)
 			return
 		}
 
 		name2 := mux.Vars(r)["name2"]
 		inst2, err := c.GetInstance(ctx, name2)
 		if err != nil {
-			resp.Message = fmt.Sprintf("could not find instance %v", name2)
-			encode(w, r, http.StatusNotFound, resp)
+			fail(w, r, err)
 			return
 		}
 
 		connected, err := c.GetConnection(ctx, inst1, inst2)
 		if err != nil {
-			resp.Message = err.Error()
-			encode(w, r, http.StatusInternalServerError, resp)
+			fail(w, r, err)
 			return
 		}
 
@@ -253,23 +348,20 @@ func connectHandler(c *control.ControlPlane) http.Handler {
 		name1 := mux.Vars(r)["name1"]
 		inst1, err := c.GetInstance(ctx, name1)
 		if err != nil {
-			resp.Message = fmt.Sprintf("could not find instance %v", name1)
-			encode(w, r, http.StatusNotFound, resp)
+			fail(w, r, err)
 			return
 		}
 
 		name2 := mux.Vars(r)["name2"]
 		inst2, err := c.GetInstance(ctx, name2)
 		if err != nil {
-			resp.Message = fmt.Sprintf("could not find instance %v", name2)
-			encode(w, r, http.StatusNotFound, resp)
+			fail(w, r, err)
 			return
 		}
 
 		err = c.Connect(ctx, inst1, inst2)
 		if err != nil {
-			resp.Message = err.Error()
-			encode(w, r, http.StatusInternalServerError, resp)
+			fail(w, r, err)
 			return
 		}
 
@@ -292,23 +384,20 @@ func disconnectHandler(c *control.ControlPlane) http.Handler {
 		name1 := mux.Vars(r)["name1"]
 		inst1, err := c.GetInstance(ctx, name1)
 		if err != nil {
-			resp.Message = fmt.Sprintf("could not find instance %v", name1)
-			encode(w, r, http.StatusNotFound, resp)
+			fail(w, r, err)
 			return
 		}
 
 		name2 := mux.Vars(r)["name2"]
 		inst2, err := c.GetInstance(ctx, name2)
 		if err != nil {
-			resp.Message = fmt.Sprintf("could not find instance %v", name2)
-			encode(w, r, http.StatusNotFound, resp)
+			fail(w, r, err)
 			return
 		}
 
 		err = c.Disconnect(ctx, inst1, inst2)
 		if err != nil {
-			resp.Message = err.Error()
-			encode(w, r, http.StatusInternalServerError, resp)
+			fail(w, r, err)
 			return
 		}
 
@@ -319,27 +408,21 @@ func disconnectHandler(c *control.ControlPlane) http.Handler {
 }
 
 type GetKeysSuccessResponse = []control.KV
-type GetKeysFailResponse struct {
-	Message string
-}
 
 func getKeysHandler(c *control.ControlPlane) http.Handler {
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		var resp GetKeysFailResponse
 
 		name := mux.Vars(r)["name"]
 		inst, err := c.GetInstance(ctx, name)
 		if err != nil {
-			resp.Message = fmt.Sprintf("could not find instance %v", name)
-			encode(w, r, http.StatusNotFound, resp)
+			fail(w, r, err)
 			return
 		}
 
 		vals, err := control.Get(ctx, inst)
 		if err != nil {
-			resp.Message = err.Error()
-			encode(w, r, http.StatusInternalServerError, resp)
+			fail(w, r, err)
 			return
 		}
 
@@ -350,7 +433,7 @@ func getKeysHandler(c *control.ControlPlane) http.Handler {
 }
 
 type PutKeysBody struct {
-	Value string
+	Value string `openapi:"required"`
 }
 type PutKeysResponse struct {
 	Message string
@@ -364,23 +447,20 @@ func putKeysHandler(c *control.ControlPlane) http.Handler {
 		name := mux.Vars(r)["name"]
 		inst, err := c.GetInstance(ctx, name)
 		if err != nil {
-			resp.Message = fmt.Sprintf("could not find instance %v", name)
-			encode(w, r, http.StatusNotFound, resp)
+			fail(w, r, err)
 			return
 		}
 
 		key := mux.Vars(r)["key"]
 		value, err := decode[PutKeysBody](r)
 		if err != nil {
-			resp.Message = "unable to read value"
-			encode(w, r, http.StatusBadRequest, resp)
+			badRequest(w, r, "unable to read value")
 			return
 		}
 
 		err = control.Put(ctx, inst, key, value.Value)
 		if err != nil {
-			resp.Message = err.Error()
-			encode(w, r, http.StatusInternalServerError, resp)
+			fail(w, r, err)
 			return
 		}
 
@@ -391,13 +471,1145 @@ func putKeysHandler(c *control.ControlPlane) http.Handler {
 	return http.HandlerFunc(handler)
 }
 
-func pingHandler() http.Handler {
+type GetParametersSuccessResponse = []control.Parameter
+
+func getParametersHandler(c *control.ControlPlane) http.Handler {
 	handler := func(w http.ResponseWriter, r *http.Request) {
-		encode(w, r, http.StatusOK, struct {
-			Message string
-		}{
-			Message: "OK",
-		})
+		ctx := r.Context()
+
+		name := mux.Vars(r)["name"]
+		inst, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		params, err := control.GetParameters(ctx, inst)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		encode(w, r, http.StatusOK, params)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type SetParametersBody struct {
+	Parameters map[string]string `openapi:"required"`
+}
+type SetParametersResponse struct {
+	Message string
+}
+
+func setParametersHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var resp SetParametersResponse
+
+		name := mux.Vars(r)["name"]
+		inst, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		body, err := decode[SetParametersBody](r)
+		if err != nil {
+			badRequest(w, r, "cannot decode request")
+			return
+		}
+
+		err = control.ValidateParameters(body.Parameters)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		err = control.SetParameters(ctx, inst, body.Parameters)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		resp.Message = "OK"
+		encode(w, r, http.StatusOK, resp)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type GetResourcesSuccessResponse = control.Resources
+
+func getResourcesHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		name := mux.Vars(r)["name"]
+		inst, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		res, err := c.GetResources(ctx, inst)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		encode(w, r, http.StatusOK, res)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type UpdateResourcesBody = control.Resources
+type UpdateResourcesResponse struct {
+	Message string
+}
+
+func updateResourcesHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var resp UpdateResourcesResponse
+
+		name := mux.Vars(r)["name"]
+		inst, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		body, err := decode[UpdateResourcesBody](r)
+		if err != nil {
+			badRequest(w, r, "cannot decode request")
+			return
+		}
+
+		err = control.ValidateResources(body)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		err = c.UpdateResources(ctx, inst, body)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		resp.Message = "OK"
+		encode(w, r, http.StatusOK, resp)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type PauseInstanceResponse struct {
+	Message string
+}
+
+func pauseInstanceHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var resp PauseInstanceResponse
+
+		name := mux.Vars(r)["name"]
+		inst, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		err = c.PauseInstance(ctx, inst)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		resp.Message = "OK"
+		encode(w, r, http.StatusOK, resp)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type UnpauseInstanceResponse struct {
+	Message string
+}
+
+func unpauseInstanceHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var resp UnpauseInstanceResponse
+
+		name := mux.Vars(r)["name"]
+		inst, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		err = c.UnpauseInstance(ctx, inst)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		resp.Message = "OK"
+		encode(w, r, http.StatusOK, resp)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type SignalInstanceBody struct {
+	Signal string `openapi:"required"`
+}
+type SignalInstanceResponse struct {
+	Message string
+}
+
+func signalInstanceHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var resp SignalInstanceResponse
+
+		name := mux.Vars(r)["name"]
+		inst, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		body, err := decode[SignalInstanceBody](r)
+		if err != nil {
+			badRequest(w, r, "cannot decode request")
+			return
+		}
+
+		if !slices.Contains(control.SIGNALS, body.Signal) {
+			badRequest(w, r, fmt.Sprintf("signal must be one of %v", control.SIGNALS))
+			return
+		}
+
+		err = c.SignalInstance(ctx, inst, body.Signal)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		resp.Message = "OK"
+		encode(w, r, http.StatusOK, resp)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type RestartInstanceSuccessResponse = control.Instance
+
+func restartInstanceHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		name := mux.Vars(r)["name"]
+		inst, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		inst, err = c.RestartInstance(ctx, inst)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		encode(w, r, http.StatusOK, inst)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type CreateSnapshotBody struct {
+	Name string `openapi:"required"`
+}
+type CreateSnapshotSuccessResponse = control.Snapshot
+
+func createSnapshotHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		name := mux.Vars(r)["name"]
+		inst, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		body, err := decode[CreateSnapshotBody](r)
+		if err != nil {
+			badRequest(w, r, "cannot decode request")
+			return
+		}
+
+		if body.Name == "" {
+			badRequest(w, r, "invalid snapshot name")
+			return
+		}
+
+		snap, err := c.CreateSnapshot(ctx, inst, body.Name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		encode(w, r, http.StatusOK, snap)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type ListSnapshotsSuccessResponse = []control.Snapshot
+
+func listSnapshotsHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		snaps, err := c.ListSnapshots(ctx)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		encode(w, r, http.StatusOK, snaps)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type DeleteSnapshotResponse struct {
+	Message string
+}
+
+func deleteSnapshotHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var resp DeleteSnapshotResponse
+
+		name := mux.Vars(r)["snapshot"]
+		snap, err := c.GetSnapshot(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		err = c.DeleteSnapshot(ctx, snap)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		resp.Message = "OK"
+		encode(w, r, http.StatusOK, resp)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type RestoreSnapshotBody struct {
+	Snapshot string `openapi:"required"`
+}
+type RestoreSnapshotSuccessResponse = control.Instance
+
+func restoreSnapshotHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		name := mux.Vars(r)["name"]
+		inst, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		body, err := decode[RestoreSnapshotBody](r)
+		if err != nil {
+			badRequest(w, r, "cannot decode request")
+			return
+		}
+
+		snap, err := c.GetSnapshot(ctx, body.Snapshot)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		inst, err = c.RestoreSnapshot(ctx, inst, snap)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		encode(w, r, http.StatusOK, inst)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type CloneSnapshotBody struct {
+	Name string `openapi:"required"`
+}
+type CloneSnapshotSuccessResponse = control.Instance
+
+func cloneSnapshotHandler(c *control.ControlPlane, image string) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		name := mux.Vars(r)["snapshot"]
+		snap, err := c.GetSnapshot(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		body, err := decode[CloneSnapshotBody](r)
+		if err != nil {
+			badRequest(w, r, "cannot decode request")
+			return
+		}
+
+		err = control.ValidateName(body.Name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		inst, err := c.AddInstance(ctx, body.Name, image, control.FromSnapshot(snap))
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		encode(w, r, http.StatusOK, inst)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type CloneInstanceBody struct {
+	Name string `openapi:"required"`
+}
+type CloneInstanceSuccessResponse = control.Instance
+
+func cloneInstanceHandler(c *control.ControlPlane, image string) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		name := mux.Vars(r)["name"]
+		source, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		body, err := decode[CloneInstanceBody](r)
+		if err != nil {
+			badRequest(w, r, "cannot decode request")
+			return
+		}
+
+		err = control.ValidateName(body.Name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		inst, err := c.CloneInstance(ctx, source, body.Name, image)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		encode(w, r, http.StatusOK, inst)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type ListSlotsSuccessResponse = []control.Slot
+
+func listSlotsHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		name := mux.Vars(r)["name"]
+		inst, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		slots, err := control.ListSlots(ctx, inst)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		encode(w, r, http.StatusOK, slots)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type DropSlotResponse struct {
+	Message string
+}
+
+func dropSlotHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var resp DropSlotResponse
+
+		name := mux.Vars(r)["name"]
+		inst, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		// ?force=true also kicks out whoever is streaming from the slot
+		slot := mux.Vars(r)["slot"]
+		force := r.URL.Query().Get("force") == "true"
+
+		err = control.DropSlot(ctx, inst, slot, force)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		resp.Message = "OK"
+		encode(w, r, http.StatusOK, resp)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type GetSubscriptionsSuccessResponse = []control.Subscription
+
+func getSubscriptionsHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		name := mux.Vars(r)["name"]
+		inst, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		subs, err := c.GetSubscriptions(ctx, inst)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		encode(w, r, http.StatusOK, subs)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type SkipTransactionBody struct {
+	Lsn string `openapi:"required"`
+}
+type SkipTransactionResponse struct {
+	Message string
+}
+
+func skipTransactionHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var resp SkipTransactionResponse
+
+		name := mux.Vars(r)["name"]
+		inst, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		body, err := decode[SkipTransactionBody](r)
+		if err != nil {
+			badRequest(w, r, "cannot decode request")
+			return
+		}
+
+		sub := mux.Vars(r)["sub"]
+		err = c.SkipTransaction(ctx, inst, sub, body.Lsn)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		resp.Message = "OK"
+		encode(w, r, http.StatusOK, resp)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type SetupMultiMasterBody struct {
+	Instances []string `openapi:"required"`
+}
+type SetupMultiMasterResponse struct {
+	Message string
+}
+
+func setupMultiMasterHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var resp SetupMultiMasterResponse
+
+		body, err := decode[SetupMultiMasterBody](r)
+		if err != nil {
+			badRequest(w, r, "cannot decode request")
+			return
+		}
+
+		insts := make([]control.Instance, len(body.Instances))
+		for i, name := range body.Instances {
+			insts[i], err = c.GetInstance(ctx, name)
+			if err != nil {
+				fail(w, r, err)
+				return
+			}
+		}
+
+		err = c.SetupMultiMaster(ctx, insts)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		resp.Message = "OK"
+		encode(w, r, http.StatusOK, resp)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type GetConflictsSuccessResponse = control.Conflicts
+
+func getConflictsHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		// ?instances=a,b,c
+		names := strings.Split(r.URL.Query().Get("instances"), ",")
+		if len(names) < 2 {
+			badRequest(w, r, "need at least two instances to compare")
+			return
+		}
+
+		insts := make([]control.Instance, len(names))
+		for i, name := range names {
+			var err error
+			insts[i], err = c.GetInstance(ctx, name)
+			if err != nil {
+				fail(w, r, err)
+				return
+			}
+		}
+
+		conflicts, err := c.DetectConflicts(ctx, insts)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		encode(w, r, http.StatusOK, conflicts)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type GetTopologySuccessResponse = control.Topology
+
+func getTopologyHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		topo, err := c.GetTopology(ctx)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		encode(w, r, http.StatusOK, topo)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type PingResponse struct {
+	Message string
+}
+
+func pingHandler() http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		encode(w, r, http.StatusOK, PingResponse{Message: "OK"})
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type GetSchemaSuccessResponse = control.Schema
+
+func getSchemaHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		encode(w, r, http.StatusOK, c.Schema())
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type SetSchemaBody = control.Schema
+type SetSchemaResponse struct {
+	Message string
+}
+
+// Only instances added afterwards get the new schema.
+func setSchemaHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var resp SetSchemaResponse
+
+		body, err := decode[SetSchemaBody](r)
+		if err != nil {
+			badRequest(w, r, "cannot decode request")
+			return
+		}
+
+		err = c.SetSchema(body)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		resp.Message = "schema updated"
+		encode(w, r, http.StatusOK, resp)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type QueryBody struct {
+	Query     string `openapi:"required"`
+	TimeoutMs int64  // defaults to 5 seconds, at most a minute
+	ReadOnly  bool
+}
+type QuerySuccessResponse = control.QueryResult
+
+func queryHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		name := mux.Vars(r)["name"]
+		inst, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		body, err := decode[QueryBody](r)
+		if err != nil {
+			badRequest(w, r, "cannot decode request")
+			return
+		}
+
+		if strings.TrimSpace(body.Query) == "" {
+			badRequest(w, r, "query cannot be empty")
+			return
+		}
+
+		res, err := control.Query(ctx, inst, body.Query, control.QueryOptions{
+			Timeout:  time.Duration(body.TimeoutMs) * time.Millisecond,
+			ReadOnly: body.ReadOnly,
+		})
+		if err != nil {
+			// mostly mistakes in the query itself
+			fail(w, r, err)
+			return
+		}
+
+		encode(w, r, http.StatusOK, res)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// Sent by the browser as text messages.
+// Terminal output goes the other way as binary messages.
+type TerminalMessage struct {
+	Type string // input or resize
+	Data string // for input
+	Cols uint   // for resize
+	Rows uint   // for resize
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+func terminalHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		name := mux.Vars(r)["name"]
+		inst, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		cols, rows := uint(80), uint(24)
+		if v := r.URL.Query().Get("cols"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 16)
+			if err != nil || n == 0 {
+				badRequest(w, r, "invalid cols")
+				return
+			}
+			cols = uint(n)
+		}
+		if v := r.URL.Query().Get("rows"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 16)
+			if err != nil || n == 0 {
+				badRequest(w, r, "invalid rows")
+				return
+			}
+			rows = uint(n)
+		}
+
+		term, err := c.OpenTerminal(ctx, inst, cols, rows)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+		defer term.Close()
+
+		// the upgrader writes its own error response
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+
+		// psql exiting ends the output, which closes the socket
+		go func() {
+			buf := make([]byte, 4096)
+			for {
+				n, err := term.Read(buf)
+				if n > 0 {
+					if werr := ws.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+						break
+					}
+				}
+				if err != nil {
+					break
+				}
+			}
+			ws.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, "psql exited"),
+				time.Now().Add(time.Second))
+			ws.Close()
+		}()
+
+		for {
+			var msg TerminalMessage
+			err := ws.ReadJSON(&msg)
+			if err != nil {
+				return
+			}
+
+			switch msg.Type {
+			case "input":
+				_, err = term.Write([]byte(msg.Data))
+			case "resize":
+				if msg.Cols != 0 && msg.Rows != 0 {
+					err = term.Resize(ctx, msg.Cols, msg.Rows)
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// Sends every line as its own event when the client accepts text/event-stream,
+// plain chunked text otherwise.
+func streamLogsHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		name := mux.Vars(r)["name"]
+		inst, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		query := r.URL.Query()
+		opts := control.LogOptions{
+			Follow:     query.Get("follow") == "true",
+			Since:      query.Get("since"),
+			Tail:       query.Get("tail"),
+			Timestamps: query.Get("timestamps") == "true",
+		}
+
+		logs, err := c.StreamLogs(ctx, inst, opts)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+		defer logs.Close()
+
+		sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+		if sse {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusOK)
+
+		flusher, _ := w.(http.Flusher)
+		scanner := bufio.NewScanner(logs)
+		for scanner.Scan() {
+			var err error
+			if sse {
+				_, err = fmt.Fprintf(w, "data: %s\n\n", scanner.Text())
+			} else {
+				_, err = fmt.Fprintf(w, "%s\n", scanner.Text())
+			}
+			if err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// Streams control plane events as server-sent events, with the event as json in the data field.
+// The events are left unnamed so browsers deliver all of them to onmessage.
+// Reconnecting clients get what they missed through Last-Event-ID, others can pass ?after= for the same.
+func streamEventsHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		after := r.Header.Get("Last-Event-ID")
+		if after == "" {
+			after = r.URL.Query().Get("after")
+		}
+
+		var afterID uint64
+		if after != "" {
+			id, err := strconv.ParseUint(after, 10, 64)
+			if err != nil {
+				badRequest(w, r, "invalid event id")
+				return
+			}
+			afterID = id
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			fail(w, r, fmt.Errorf("streaming is not supported"))
+			return
+		}
+
+		events, cancel := c.SubscribeEvents(afterID)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		// keeps proxies from closing an idle stream
+		heartbeat := time.NewTicker(15 * time.Second)
+		defer heartbeat.Stop()
+
+		for {
+			var err error
+			select {
+			case <-ctx.Done():
+				return
+			case <-heartbeat.C:
+				_, err = fmt.Fprint(w, ": heartbeat\n\n")
+			case e := <-events:
+				var data []byte
+				data, err = json.Marshal(e)
+				if err == nil {
+					_, err = fmt.Fprintf(w, "id: %v\ndata: %s\n\n", e.ID, data)
+				}
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type GetTrafficSuccessResponse = []control.LinkTraffic
+
+func getTrafficHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		traffic, err := c.GetTraffic(ctx)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		encode(w, r, http.StatusOK, traffic)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type StartCaptureBody struct {
+	DurationMs int64  // defaults to 10 seconds, at most 5 minutes
+	Filter     string // a pcap filter expression, e.g. "tcp port 5432"
+}
+type StartCaptureSuccessResponse = control.Capture
+
+// The capture keeps running after the response, poll it until it's done before downloading.
+func startCaptureHandler(c *control.ControlPlane, image string) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		name := mux.Vars(r)["name"]
+		inst, err := c.GetInstance(ctx, name)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		body, err := decode[StartCaptureBody](r)
+		if err != nil {
+			badRequest(w, r, "cannot decode request")
+			return
+		}
+
+		duration := time.Duration(body.DurationMs) * time.Millisecond
+		if duration < 0 || duration > control.MAX_CAPTURE_DURATION {
+			badRequest(w, r, fmt.Sprintf("duration must be between 0 and %v", control.MAX_CAPTURE_DURATION))
+			return
+		}
+
+		capture, err := c.StartCapture(ctx, inst, image, duration, body.Filter)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		encode(w, r, http.StatusAccepted, capture)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type ListCapturesResponse = []control.Capture
+
+func listCapturesHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		encode(w, r, http.StatusOK, c.ListCaptures())
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type GetCaptureSuccessResponse = control.Capture
+
+func getCaptureHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+
+		id := mux.Vars(r)["capture"]
+		capture, err := c.GetCapture(id)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		encode(w, r, http.StatusOK, capture)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+func downloadCaptureHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+
+		id := mux.Vars(r)["capture"]
+		capture, err := c.GetCapture(id)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		f, err := c.OpenCapture(id)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", capture.Instance+"-"+capture.ID+".pcap"))
+		http.ServeContent(w, r, "", capture.StartedAt, f)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+type DeleteCaptureResponse struct {
+	Message string
+}
+
+func deleteCaptureHandler(c *control.ControlPlane) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var resp DeleteCaptureResponse
+
+		id := mux.Vars(r)["capture"]
+		_, err := c.GetCapture(id)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		err = c.DeleteCapture(id)
+		if err != nil {
+			fail(w, r, err)
+			return
+		}
+
+		resp.Message = "capture deleted"
+		encode(w, r, http.StatusOK, resp)
 	}
 
 	return http.HandlerFunc(handler)
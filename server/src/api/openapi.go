@@ -0,0 +1,454 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/openapi3gen"
+	"github.com/gorilla/mux"
+)
+
+// Describes a route for the OpenAPI document.
+// Schemas are generated from the go types, so they can't drift from what the handlers decode.
+type operation struct {
+	id       string
+	summary  string
+	body     any          // nil when the route takes no body
+	response any          // nil for routes that only answer with a message
+	status   int          // of the success response, 200 when unset
+	query    []queryParam // all optional
+	produces []string     // for responses that aren't json
+}
+
+type queryParam struct {
+	name        string
+	kind        string // string, boolean or integer
+	description string
+}
+
+// Every route registered in Run needs an entry here, the server won't start otherwise.
+var operations = map[string]operation{
+	"GET /ping": {id: "ping", summary: "Checks that the server is up", response: PingResponse{}},
+	"GET /openapi.json": {id: "getOpenAPI", summary: "This document",
+		produces: []string{"application/json"}},
+
+	"GET /instances":        {id: "listInstances", summary: "Lists every instance", response: ListInstanceResponse{}},
+	"POST /instances":       {id: "addInstance", summary: "Creates an instance", body: AddInstanceBody{}, response: AddInstanceSuccessResponse{}},
+	"GET /instances/{name}": {id: "getInstanceReplication", summary: "Reads the replication state of an instance", response: GetInstanceReplicationSuccess{}},
+	"PUT /instances/{name}": {id: "modifyInstance", summary: "Changes the replication role of an instance, one action at a time", body: ModifyInstanceBody{}, response: ModifyInstanceResponse{}},
+	"DELETE /instances/{name}": {id: "killInstance", summary: "Kills an instance", response: KillInstanceResponse{},
+		query: []queryParam{{"keep_volume", "boolean", "keeps the data volume around to restore from"}}},
+
+	"GET /instances/{name1}/connections/{name2}":    {id: "getConnection", summary: "Checks whether two instances can reach each other", response: GetConnectResponse{}},
+	"PUT /instances/{name1}/connections/{name2}":    {id: "connect", summary: "Connects two instances", response: ConnectResponse{}},
+	"DELETE /instances/{name1}/connections/{name2}": {id: "disconnect", summary: "Partitions two instances from each other", response: DisconnectResponse{}},
+
+	"GET /instances/{name}/keys":       {id: "getKeys", summary: "Lists the keys of an instance", response: GetKeysSuccessResponse{}},
+	"PUT /instances/{name}/keys/{key}": {id: "putKey", summary: "Writes a key on an instance", body: PutKeysBody{}, response: PutKeysResponse{}},
+
+	"GET /instances/{name}/parameters": {id: "getParameters", summary: "Lists the postgres parameters of an instance", response: GetParametersSuccessResponse{}},
+	"PUT /instances/{name}/parameters": {id: "setParameters", summary: "Changes postgres parameters, restarting when needed", body: SetParametersBody{}, response: SetParametersResponse{}},
+	"GET /instances/{name}/resources":  {id: "getResources", summary: "Reads the resource limits of an instance", response: GetResourcesSuccessResponse{}},
+	"PUT /instances/{name}/resources":  {id: "updateResources", summary: "Changes the resource limits of a running instance", body: UpdateResourcesBody{}, response: UpdateResourcesResponse{}},
+
+	"POST /instances/{name}/pause":   {id: "pauseInstance", summary: "Freezes an instance", response: PauseInstanceResponse{}},
+	"POST /instances/{name}/unpause": {id: "unpauseInstance", summary: "Thaws a paused instance", response: UnpauseInstanceResponse{}},
+	"POST /instances/{name}/signal":  {id: "signalInstance", summary: "Sends a signal to postgres", body: SignalInstanceBody{}, response: SignalInstanceResponse{}},
+	"POST /instances/{name}/restart": {id: "restartInstance", summary: "Restarts an instance, which gets a new port", response: RestartInstanceSuccessResponse{}},
+
+	"GET /instances/{name}/slots": {id: "listSlots", summary: "Lists the replication slots of an instance", response: ListSlotsSuccessResponse{}},
+	"DELETE /instances/{name}/slots/{slot}": {id: "dropSlot", summary: "Drops a replication slot", response: DropSlotResponse{},
+		query: []queryParam{{"force", "boolean", "kicks out whoever is streaming from the slot"}}},
+	"GET /instances/{name}/subscriptions":            {id: "getSubscriptions", summary: "Lists the subscriptions of an instance with their last error", response: GetSubscriptionsSuccessResponse{}},
+	"PUT /instances/{name}/subscriptions/{sub}/skip": {id: "skipTransaction", summary: "Skips the failing transaction of a subscription", body: SkipTransactionBody{}, response: SkipTransactionResponse{}},
+
+	"POST /instances/{name}/query": {id: "query", summary: "Runs sql on an instance", body: QueryBody{}, response: QuerySuccessResponse{}},
+	"GET /instances/{name}/logs": {id: "streamLogs", summary: "Streams the logs of an instance, as server-sent events when accepted",
+		produces: []string{"text/plain", "text/event-stream"},
+		query: []queryParam{
+			{"follow", "boolean", "keeps streaming new lines"},
+			{"since", "string", "a timestamp or a duration like 10m"},
+			{"tail", "string", "number of lines from the end, or all"},
+			{"timestamps", "boolean", "prefixes every line with its timestamp"},
+		}},
+	"POST /instances/{name}/capture": {id: "startCapture", summary: "Starts capturing the packets of an instance", body: StartCaptureBody{}, response: StartCaptureSuccessResponse{}, status: http.StatusAccepted},
+	"GET /instances/{name}/terminal": {id: "openTerminal", summary: "Opens psql on an instance over a WebSocket", status: http.StatusSwitchingProtocols,
+		query: []queryParam{
+			{"cols", "integer", "width of the terminal, 80 by default"},
+			{"rows", "integer", "height of the terminal, 24 by default"},
+		}},
+
+	"POST /instances/{name}/clone":     {id: "cloneInstance", summary: "Copies an instance into a new one", body: CloneInstanceBody{}, response: CloneInstanceSuccessResponse{}},
+	"POST /instances/{name}/snapshots": {id: "createSnapshot", summary: "Snapshots the data of an instance", body: CreateSnapshotBody{}, response: CreateSnapshotSuccessResponse{}},
+	"POST /instances/{name}/restore":   {id: "restoreSnapshot", summary: "Replaces the data of an instance with a snapshot", body: RestoreSnapshotBody{}, response: RestoreSnapshotSuccessResponse{}},
+
+	"GET /events": {id: "streamEvents", summary: "Streams control plane events as server-sent events",
+		produces: []string{"text/event-stream"},
+		query:    []queryParam{{"after", "integer", "replays the events after this id, like Last-Event-ID"}}},
+	"GET /schema":   {id: "getSchema", summary: "Reads the schema new instances get", response: GetSchemaSuccessResponse{}},
+	"PUT /schema":   {id: "setSchema", summary: "Changes the schema new instances get", body: SetSchemaBody{}, response: SetSchemaResponse{}},
+	"GET /topology": {id: "getTopology", summary: "Reads who replicates from whom", response: GetTopologySuccessResponse{}},
+	"GET /traffic":  {id: "getTraffic", summary: "Reads the traffic on every link between instances", response: GetTrafficSuccessResponse{}},
+
+	"POST /multimaster": {id: "setupMultiMaster", summary: "Makes every instance replicate from every other", body: SetupMultiMasterBody{}, response: SetupMultiMasterResponse{}},
+	"GET /multimaster/conflicts": {id: "getConflicts", summary: "Compares the keys of instances", response: GetConflictsSuccessResponse{},
+		query: []queryParam{{"instances", "string", "comma separated instance names"}}},
+
+	"GET /captures":                {id: "listCaptures", summary: "Lists packet captures", response: ListCapturesResponse{}},
+	"GET /captures/{capture}":      {id: "getCapture", summary: "Reads the state of a capture", response: GetCaptureSuccessResponse{}},
+	"DELETE /captures/{capture}":   {id: "deleteCapture", summary: "Deletes a capture", response: DeleteCaptureResponse{}},
+	"GET /captures/{capture}/pcap": {id: "downloadCapture", summary: "Downloads the pcap file of a finished capture", produces: []string{"application/vnd.tcpdump.pcap"}},
+
+	"GET /snapshots":                   {id: "listSnapshots", summary: "Lists snapshots", response: ListSnapshotsSuccessResponse{}},
+	"DELETE /snapshots/{snapshot}":     {id: "deleteSnapshot", summary: "Deletes a snapshot", response: DeleteSnapshotResponse{}},
+	"POST /snapshots/{snapshot}/clone": {id: "cloneSnapshot", summary: "Creates an instance from a snapshot", body: CloneSnapshotBody{}, response: CloneSnapshotSuccessResponse{}},
+}
+
+var pathParams = map[string]string{
+	"name":     "instance name, with the netpart- prefix",
+	"name1":    "instance name, with the netpart- prefix",
+	"name2":    "instance name, with the netpart- prefix",
+	"key":      "key in the kv table",
+	"slot":     "replication slot name",
+	"sub":      "subscription name",
+	"capture":  "capture id",
+	"snapshot": "snapshot name",
+}
+
+var pathParam = regexp.MustCompile(`{([^}]+)}`)
+
+// Builds the OpenAPI document from the routes of r and loads it back, which resolves the schema references for validation.
+func buildSpec(ctx context.Context, r *mux.Router) (*openapi3.T, []byte, error) {
+	spec := &openapi3.T{
+		OpenAPI: "3.0.3",
+		Info: &openapi3.Info{
+			Title:       "netpart",
+			Description: "Provisions postgres instances, sets up replication between them and partitions their networks.",
+			Version:     "1.0.0",
+		},
+		Servers:    openapi3.Servers{{URL: "/api"}},
+		Paths:      openapi3.NewPaths(),
+		Components: &openapi3.Components{Schemas: openapi3.Schemas{}},
+	}
+
+	gen := openapi3gen.NewGenerator(
+		openapi3gen.UseAllExportedFields(),
+		openapi3gen.SchemaCustomizer(customizeSchema),
+		openapi3gen.CreateComponentSchemas(openapi3gen.ExportComponentSchemasOptions{
+			ExportComponentSchemas: true,
+			ExportTopLevelSchema:   true,
+		}),
+	)
+	schemaFor := func(v any) (*openapi3.SchemaRef, error) {
+		return gen.NewSchemaRefForValue(v, spec.Components.Schemas)
+	}
+
+	failure, err := schemaFor(ErrorResponse{})
+	if err != nil {
+		return nil, nil, err
+	}
+	// the codes are plain strings in go
+	code := spec.Components.Schemas["ErrorResponse"].Value.Properties["Code"].Value
+	for _, c := range CODES {
+		code.Enum = append(code.Enum, c)
+	}
+
+	err = r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+
+		for _, method := range methods {
+			key := method + " " + path
+			desc, ok := operations[key]
+			if !ok {
+				return fmt.Errorf("%v is not documented", key)
+			}
+
+			op := openapi3.NewOperation()
+			op.OperationID = desc.id
+			op.Summary = desc.summary
+
+			for _, match := range pathParam.FindAllStringSubmatch(path, -1) {
+				param := openapi3.NewPathParameter(match[1]).WithSchema(openapi3.NewStringSchema())
+				param.Description = pathParams[match[1]]
+				op.AddParameter(param)
+			}
+			for _, q := range desc.query {
+				param := openapi3.NewQueryParameter(q.name).WithSchema(&openapi3.Schema{Type: &openapi3.Types{q.kind}})
+				param.Description = q.description
+				op.AddParameter(param)
+			}
+
+			if desc.body != nil {
+				schema, err := schemaFor(desc.body)
+				if err != nil {
+					return fmt.Errorf("%v: %w", key, err)
+				}
+				op.RequestBody = &openapi3.RequestBodyRef{
+					Value: openapi3.NewRequestBody().WithRequired(true).WithJSONSchemaRef(schema),
+				}
+			}
+
+			status := desc.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			res := openapi3.NewResponse().WithDescription(http.StatusText(status))
+			switch {
+			case desc.response != nil:
+				schema, err := schemaFor(desc.response)
+				if err != nil {
+					return fmt.Errorf("%v: %w", key, err)
+				}
+				res.WithJSONSchemaRef(schema)
+			case len(desc.produces) > 0:
+				res.Content = openapi3.NewContentWithSchema(nil, desc.produces)
+			}
+			op.AddResponse(status, res)
+			op.Responses.Set("default", &openapi3.ResponseRef{
+				Value: openapi3.NewResponse().WithDescription("Error").WithJSONSchemaRef(failure),
+			})
+
+			spec.AddOperation(path, method, op)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	loaded, err := openapi3.NewLoader().LoadFromData(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = loaded.Validate(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid openapi document: %w", err)
+	}
+
+	return loaded, data, nil
+}
+
+// Go writes nil slices, maps and pointers as null.
+// Struct fields tagged openapi:"required" must be present, under any case since validateRequests matches keys like the json decoder.
+func customizeSchema(name string, t reflect.Type, tag reflect.StructTag, schema *openapi3.Schema) error {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Pointer:
+		schema.Nullable = true
+	case reflect.Struct:
+		for i := range t.NumField() {
+			field := t.Field(i)
+			if field.Tag.Get("openapi") == "required" {
+				schema.Required = append(schema.Required, field.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func openAPIHandler(data []byte) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// Rejects request bodies that don't match the document, before they reach the handlers.
+func validateRequests(spec *openapi3.T) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			path, err := mux.CurrentRoute(r).GetPathTemplate()
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var op *openapi3.Operation
+			if item := spec.Paths.Value(path); item != nil {
+				op = item.GetOperation(r.Method)
+			}
+			if op == nil || op.RequestBody == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// bodies have always been read as json whatever the content type,
+			// and browsers send text/plain for string bodies
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if mediaType != "application/json" {
+				r.Header.Set("Content-Type", "application/json")
+			}
+
+			err = matchKeys(r, op.RequestBody.Value)
+			if err != nil {
+				badRequest(w, r, fmt.Sprintf("invalid request body: %v", err))
+				return
+			}
+
+			input := &openapi3filter.RequestValidationInput{
+				Request:    r,
+				PathParams: mux.Vars(r),
+				Options:    &openapi3filter.Options{SkipSettingDefaults: true},
+			}
+			err = openapi3filter.ValidateRequestBody(r.Context(), input, op.RequestBody.Value)
+			if err != nil {
+				badRequest(w, r, validationMessage(err))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(handler)
+	}
+}
+
+// The json decoder matches keys to struct fields case-insensitively, the schema doesn't.
+// So keys are renamed to the spelling of the schema before validating, and the handler gets the renamed body too.
+// Bodies that aren't json are left for the validator to reject.
+func matchKeys(r *http.Request, body *openapi3.RequestBody) error {
+	media := body.Content.Get("application/json")
+	if media == nil || media.Schema == nil {
+		return nil
+	}
+
+	data, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	// numbers are kept as written, a float64 would round large ones
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if dec.Decode(&v) == nil {
+		v, err = renameKeys(v, media.Schema.Value)
+		if err != nil {
+			return err
+		}
+
+		renamed, err := json.Marshal(v)
+		if err == nil {
+			data = renamed
+		}
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	r.ContentLength = int64(len(data))
+	return nil
+}
+
+// Keys that only differ by case would land on the same field, and which one wins would depend on map order.
+// Those bodies are refused instead.
+func renameKeys(v any, schema *openapi3.Schema) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		out := make(map[string]any, len(v))
+		from := make(map[string]string, len(v))
+		for _, key := range keys {
+			name, prop := schemaProperty(schema, key)
+			if prev, ok := from[name]; ok {
+				return nil, fmt.Errorf("keys %q and %q are the same field", prev, key)
+			}
+			from[name] = key
+
+			val, err := renameKeys(v[key], prop)
+			if err != nil {
+				return nil, err
+			}
+			out[name] = val
+		}
+		return out, nil
+	case []any:
+		var items *openapi3.Schema
+		if schema != nil && schema.Items != nil {
+			items = schema.Items.Value
+		}
+		for i := range v {
+			val, err := renameKeys(v[i], items)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = val
+		}
+		return v, nil
+	}
+	return v, nil
+}
+
+// Finds the property a key decodes into, an exact match first like the json decoder.
+func schemaProperty(schema *openapi3.Schema, key string) (string, *openapi3.Schema) {
+	if schema == nil {
+		return key, nil
+	}
+	if prop, ok := schema.Properties[key]; ok {
+		return key, prop.Value
+	}
+	for name, prop := range schema.Properties {
+		if strings.EqualFold(name, key) {
+			return name, prop.Value
+		}
+	}
+	// maps keep their keys
+	if schema.AdditionalProperties.Schema != nil {
+		return key, schema.AdditionalProperties.Schema.Value
+	}
+	return key, nil
+}
+
+// The errors of the validator embed the whole schema, so only the reason and where it failed are kept.
+func validationMessage(err error) string {
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		if ptr := schemaErr.JSONPointer(); len(ptr) > 0 {
+			return fmt.Sprintf("invalid request body at %v: %v", strings.Join(ptr, "."), schemaErr.Reason)
+		}
+		return fmt.Sprintf("invalid request body: %v", schemaErr.Reason)
+	}
+
+	if errors.Is(err, openapi3filter.ErrInvalidRequired) {
+		return "request body is required"
+	}
+
+	var reqErr *openapi3filter.RequestError
+	if errors.As(err, &reqErr) && reqErr.Reason != "" {
+		return fmt.Sprintf("invalid request body: %v", reqErr.Reason)
+	}
+
+	return fmt.Sprintf("invalid request body: %v", err)
+}
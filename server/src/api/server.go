@@ -2,13 +2,15 @@ package api
 
 import (
 	"context"
-	"fmt"
+	"log/slog"
 	"net/http"
 	"netpart/control"
 	"os"
 
 	"github.com/docker/docker/client"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func Run(ctx context.Context, addr string) {
@@ -17,11 +19,13 @@ func Run(ctx context.Context, addr string) {
 		panic(err)
 	}
 
-	err = c.Cleanup(ctx)
+	err = c.Cleanup(ctx, false)
 	if err != nil {
 		panic(err)
 	}
 
+	go c.TrackTraffic(ctx)
+
 	r := mux.NewRouter()
 	r.Handle("/ping", pingHandler()).Methods("GET")
 	r.Handle("/instances", listInstanceHandler(c)).Methods("GET")
@@ -34,10 +38,53 @@ func Run(ctx context.Context, addr string) {
 	r.Handle("/instances/{name1}/connections/{name2}", disconnectHandler(c)).Methods("DELETE")
 	r.Handle("/instances/{name}/keys", getKeysHandler(c)).Methods("GET")
 	r.Handle("/instances/{name}/keys/{key}", putKeysHandler(c)).Methods("PUT")
+	r.Handle("/instances/{name}/parameters", getParametersHandler(c)).Methods("GET")
+	r.Handle("/instances/{name}/parameters", setParametersHandler(c)).Methods("PUT")
+	r.Handle("/instances/{name}/resources", getResourcesHandler(c)).Methods("GET")
+	r.Handle("/instances/{name}/resources", updateResourcesHandler(c)).Methods("PUT")
+	r.Handle("/instances/{name}/pause", pauseInstanceHandler(c)).Methods("POST")
+	r.Handle("/instances/{name}/unpause", unpauseInstanceHandler(c)).Methods("POST")
+	r.Handle("/instances/{name}/signal", signalInstanceHandler(c)).Methods("POST")
+	r.Handle("/instances/{name}/restart", restartInstanceHandler(c)).Methods("POST")
+	r.Handle("/instances/{name}/slots", listSlotsHandler(c)).Methods("GET")
+	r.Handle("/instances/{name}/slots/{slot}", dropSlotHandler(c)).Methods("DELETE")
+	r.Handle("/instances/{name}/subscriptions", getSubscriptionsHandler(c)).Methods("GET")
+	r.Handle("/instances/{name}/subscriptions/{sub}/skip", skipTransactionHandler(c)).Methods("PUT")
+	r.Handle("/instances/{name}/query", queryHandler(c)).Methods("POST")
+	r.Handle("/instances/{name}/logs", streamLogsHandler(c)).Methods("GET")
+	r.Handle("/instances/{name}/capture", startCaptureHandler(c, os.Getenv("CAPTURE_IMAGE"))).Methods("POST")
+	r.Handle("/instances/{name}/terminal", terminalHandler(c)).Methods("GET")
+	r.Handle("/instances/{name}/clone", cloneInstanceHandler(c, os.Getenv("POSTGRES_IMAGE"))).Methods("POST")
+	r.Handle("/instances/{name}/snapshots", createSnapshotHandler(c)).Methods("POST")
+	r.Handle("/instances/{name}/restore", restoreSnapshotHandler(c)).Methods("POST")
+	r.Handle("/events", streamEventsHandler(c)).Methods("GET")
+	r.Handle("/schema", getSchemaHandler(c)).Methods("GET")
+	r.Handle("/schema", setSchemaHandler(c)).Methods("PUT")
+	r.Handle("/topology", getTopologyHandler(c)).Methods("GET")
+	r.Handle("/traffic", getTrafficHandler(c)).Methods("GET")
+	r.Handle("/multimaster", setupMultiMasterHandler(c)).Methods("POST")
+	r.Handle("/multimaster/conflicts", getConflictsHandler(c)).Methods("GET")
+	r.Handle("/captures", listCapturesHandler(c)).Methods("GET")
+	r.Handle("/captures/{capture}", getCaptureHandler(c)).Methods("GET")
+	r.Handle("/captures/{capture}", deleteCaptureHandler(c)).Methods("DELETE")
+	r.Handle("/captures/{capture}/pcap", downloadCaptureHandler(c)).Methods("GET")
+	r.Handle("/snapshots", listSnapshotsHandler(c)).Methods("GET")
+	r.Handle("/snapshots/{snapshot}", deleteSnapshotHandler(c)).Methods("DELETE")
+	r.Handle("/snapshots/{snapshot}/clone", cloneSnapshotHandler(c, os.Getenv("POSTGRES_IMAGE"))).Methods("POST")
+
+	spec, doc, err := buildSpec(ctx, r)
+	if err != nil {
+		panic(err)
+	}
+	r.Handle("/openapi.json", openAPIHandler(doc)).Methods("GET")
+	r.Use(validateRequests(spec))
+
+	http.Handle("/api/", withRequestLogging(http.StripPrefix("/api", r)))
 
-	http.Handle("/api/", http.StripPrefix("/api", r))
+	prometheus.MustRegister(c.Collector())
+	http.Handle("/metrics", promhttp.Handler())
 
-	fmt.Printf("Listening at %v\n", addr)
+	slog.InfoContext(ctx, "listening", "addr", addr)
 	err = http.ListenAndServe(addr, nil)
 	if err != nil {
 		panic(err)
@@ -0,0 +1,166 @@
+// Package client talks to the netpart HTTP API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"netpart/api"
+	"strings"
+)
+
+type Client struct {
+	base string // the server's root, without the /api prefix
+	http *http.Client
+}
+
+type Option func(*Client)
+
+func WithHTTPClient(hc *http.Client) Option {
+	return func(cl *Client) {
+		cl.http = hc
+	}
+}
+
+// New makes a client for the server at addr, e.g. http://localhost:7000.
+func New(addr string, opts ...Option) *Client {
+	cl := &Client{
+		base: strings.TrimSuffix(addr, "/"),
+		http: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(cl)
+	}
+	return cl
+}
+
+// Error is returned for every response that isn't a success.
+// Code is one of the api.CODE_* values.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("netpart: %v", http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("netpart: %v", e.Message)
+}
+
+func hasCode(err error, code string) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code == code
+	}
+	return false
+}
+
+func IsInvalid(err error) bool {
+	return hasCode(err, api.CODE_INVALID)
+}
+
+func IsNotFound(err error) bool {
+	return hasCode(err, api.CODE_NOT_FOUND)
+}
+
+func IsAlreadyExists(err error) bool {
+	return hasCode(err, api.CODE_ALREADY_EXISTS)
+}
+
+// The target isn't in a state that allows the request.
+func IsConflict(err error) bool {
+	return hasCode(err, api.CODE_CONFLICT)
+}
+
+func IsUnreachable(err error) bool {
+	return hasCode(err, api.CODE_UNREACHABLE)
+}
+
+func IsTimeout(err error) bool {
+	return hasCode(err, api.CODE_TIMEOUT)
+}
+
+func (cl *Client) url(path string, query url.Values) string {
+	u := cl.base + "/api" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// Sends the request and returns the response as is when it succeeded.
+func (cl *Client) send(ctx context.Context, method string, path string, query url.Values, body any, header http.Header) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, cl.url(path, query), reader)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := cl.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		defer res.Body.Close()
+		return nil, readError(res)
+	}
+
+	return res, nil
+}
+
+// Failures that never reached a handler, e.g. from a proxy, have no json body and keep only the status.
+func readError(res *http.Response) *Error {
+	apiErr := &Error{StatusCode: res.StatusCode}
+	var payload api.ErrorResponse
+	if json.NewDecoder(res.Body).Decode(&payload) == nil {
+		apiErr.Code = payload.Code
+		apiErr.Message = payload.Message
+	}
+	return apiErr
+}
+
+// Sends body as json and decodes the json response into out, unless out is nil.
+func (cl *Client) do(ctx context.Context, method string, path string, query url.Values, body any, out any) error {
+	res, err := cl.send(ctx, method, path, query, body, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+
+	err = json.NewDecoder(res.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("decode json: %w", err)
+	}
+	return nil
+}
+
+// escapes a single path segment
+func seg(s string) string {
+	return url.PathEscape(s)
+}
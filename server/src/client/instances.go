@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"netpart/api"
+	"netpart/control"
+)
+
+func (cl *Client) Ping(ctx context.Context) error {
+	return cl.do(ctx, http.MethodGet, "/ping", nil, nil, nil)
+}
+
+func (cl *Client) ListInstances(ctx context.Context) ([]control.Instance, error) {
+	var ret api.ListInstanceResponse
+	err := cl.do(ctx, http.MethodGet, "/instances", nil, nil, &ret)
+	return ret, err
+}
+
+// The returned instance's Name carries the netpart prefix, and is what the other methods expect.
+func (cl *Client) AddInstance(ctx context.Context, body api.AddInstanceBody) (control.Instance, error) {
+	var ret api.AddInstanceSuccessResponse
+	err := cl.do(ctx, http.MethodPost, "/instances", nil, body, &ret)
+	return ret, err
+}
+
+func (cl *Client) GetReplication(ctx context.Context, name string) (control.ReplicationData, error) {
+	var ret api.GetInstanceReplicationSuccess
+	err := cl.do(ctx, http.MethodGet, "/instances/"+seg(name), nil, nil, &ret)
+	return ret, err
+}
+
+func (cl *Client) KillInstance(ctx context.Context, name string, keepVolume bool) error {
+	query := url.Values{}
+	if keepVolume {
+		query.Set("keep_volume", "true")
+	}
+	return cl.do(ctx, http.MethodDelete, "/instances/"+seg(name), query, nil, nil)
+}
+
+// Changes the role of an instance, one action per call.
+func (cl *Client) ModifyInstance(ctx context.Context, name string, body api.ModifyInstanceBody) error {
+	return cl.do(ctx, http.MethodPut, "/instances/"+seg(name), nil, body, nil)
+}
+
+func (cl *Client) GetConnection(ctx context.Context, name1 string, name2 string) (bool, error) {
+	var ret api.GetConnectResponse
+	err := cl.do(ctx, http.MethodGet, "/instances/"+seg(name1)+"/connections/"+seg(name2), nil, nil, &ret)
+	return ret.Connected, err
+}
+
+func (cl *Client) Connect(ctx context.Context, name1 string, name2 string) error {
+	return cl.do(ctx, http.MethodPut, "/instances/"+seg(name1)+"/connections/"+seg(name2), nil, nil, nil)
+}
+
+func (cl *Client) Disconnect(ctx context.Context, name1 string, name2 string) error {
+	return cl.do(ctx, http.MethodDelete, "/instances/"+seg(name1)+"/connections/"+seg(name2), nil, nil, nil)
+}
+
+func (cl *Client) GetKeys(ctx context.Context, name string) ([]control.KV, error) {
+	var ret api.GetKeysSuccessResponse
+	err := cl.do(ctx, http.MethodGet, "/instances/"+seg(name)+"/keys", nil, nil, &ret)
+	return ret, err
+}
+
+func (cl *Client) PutKey(ctx context.Context, name string, key string, value string) error {
+	return cl.do(ctx, http.MethodPut, "/instances/"+seg(name)+"/keys/"+seg(key), nil, api.PutKeysBody{Value: value}, nil)
+}
+
+func (cl *Client) GetParameters(ctx context.Context, name string) ([]control.Parameter, error) {
+	var ret api.GetParametersSuccessResponse
+	err := cl.do(ctx, http.MethodGet, "/instances/"+seg(name)+"/parameters", nil, nil, &ret)
+	return ret, err
+}
+
+func (cl *Client) SetParameters(ctx context.Context, name string, params map[string]string) error {
+	return cl.do(ctx, http.MethodPut, "/instances/"+seg(name)+"/parameters", nil, api.SetParametersBody{Parameters: params}, nil)
+}
+
+func (cl *Client) GetResources(ctx context.Context, name string) (control.Resources, error) {
+	var ret api.GetResourcesSuccessResponse
+	err := cl.do(ctx, http.MethodGet, "/instances/"+seg(name)+"/resources", nil, nil, &ret)
+	return ret, err
+}
+
+func (cl *Client) UpdateResources(ctx context.Context, name string, res control.Resources) error {
+	return cl.do(ctx, http.MethodPut, "/instances/"+seg(name)+"/resources", nil, res, nil)
+}
+
+func (cl *Client) PauseInstance(ctx context.Context, name string) error {
+	return cl.do(ctx, http.MethodPost, "/instances/"+seg(name)+"/pause", nil, nil, nil)
+}
+
+func (cl *Client) UnpauseInstance(ctx context.Context, name string) error {
+	return cl.do(ctx, http.MethodPost, "/instances/"+seg(name)+"/unpause", nil, nil, nil)
+}
+
+func (cl *Client) SignalInstance(ctx context.Context, name string, signal string) error {
+	return cl.do(ctx, http.MethodPost, "/instances/"+seg(name)+"/signal", nil, api.SignalInstanceBody{Signal: signal}, nil)
+}
+
+// The instance comes back on a new port.
+func (cl *Client) RestartInstance(ctx context.Context, name string) (control.Instance, error) {
+	var ret api.RestartInstanceSuccessResponse
+	err := cl.do(ctx, http.MethodPost, "/instances/"+seg(name)+"/restart", nil, nil, &ret)
+	return ret, err
+}
+
+func (cl *Client) Query(ctx context.Context, name string, body api.QueryBody) (control.QueryResult, error) {
+	var ret api.QuerySuccessResponse
+	err := cl.do(ctx, http.MethodPost, "/instances/"+seg(name)+"/query", nil, body, &ret)
+	return ret, err
+}
+
+func (cl *Client) CloneInstance(ctx context.Context, name string, newName string) (control.Instance, error) {
+	var ret api.CloneInstanceSuccessResponse
+	err := cl.do(ctx, http.MethodPost, "/instances/"+seg(name)+"/clone", nil, api.CloneInstanceBody{Name: newName}, &ret)
+	return ret, err
+}
+
+func (cl *Client) GetSchema(ctx context.Context) (control.Schema, error) {
+	var ret api.GetSchemaSuccessResponse
+	err := cl.do(ctx, http.MethodGet, "/schema", nil, nil, &ret)
+	return ret, err
+}
+
+func (cl *Client) SetSchema(ctx context.Context, schema control.Schema) error {
+	return cl.do(ctx, http.MethodPut, "/schema", nil, schema, nil)
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"netpart/api"
+	"netpart/control"
+	"strings"
+)
+
+func (cl *Client) ListSlots(ctx context.Context, name string) ([]control.Slot, error) {
+	var ret api.ListSlotsSuccessResponse
+	err := cl.do(ctx, http.MethodGet, "/instances/"+seg(name)+"/slots", nil, nil, &ret)
+	return ret, err
+}
+
+// force also kicks out whoever is streaming from the slot.
+func (cl *Client) DropSlot(ctx context.Context, name string, slot string, force bool) error {
+	query := url.Values{}
+	if force {
+		query.Set("force", "true")
+	}
+	return cl.do(ctx, http.MethodDelete, "/instances/"+seg(name)+"/slots/"+seg(slot), query, nil, nil)
+}
+
+func (cl *Client) GetSubscriptions(ctx context.Context, name string) ([]control.Subscription, error) {
+	var ret api.GetSubscriptionsSuccessResponse
+	err := cl.do(ctx, http.MethodGet, "/instances/"+seg(name)+"/subscriptions", nil, nil, &ret)
+	return ret, err
+}
+
+func (cl *Client) SkipTransaction(ctx context.Context, name string, subscription string, lsn string) error {
+	return cl.do(ctx, http.MethodPut, "/instances/"+seg(name)+"/subscriptions/"+seg(subscription)+"/skip", nil, api.SkipTransactionBody{Lsn: lsn}, nil)
+}
+
+func (cl *Client) GetTopology(ctx context.Context) (control.Topology, error) {
+	var ret api.GetTopologySuccessResponse
+	err := cl.do(ctx, http.MethodGet, "/topology", nil, nil, &ret)
+	return ret, err
+}
+
+func (cl *Client) GetTraffic(ctx context.Context) ([]control.LinkTraffic, error) {
+	var ret api.GetTrafficSuccessResponse
+	err := cl.do(ctx, http.MethodGet, "/traffic", nil, nil, &ret)
+	return ret, err
+}
+
+func (cl *Client) SetupMultiMaster(ctx context.Context, names []string) error {
+	return cl.do(ctx, http.MethodPost, "/multimaster", nil, api.SetupMultiMasterBody{Instances: names}, nil)
+}
+
+func (cl *Client) GetConflicts(ctx context.Context, names []string) (control.Conflicts, error) {
+	var ret api.GetConflictsSuccessResponse
+	query := url.Values{"instances": {strings.Join(names, ",")}}
+	err := cl.do(ctx, http.MethodGet, "/multimaster/conflicts", query, nil, &ret)
+	return ret, err
+}
+
+func (cl *Client) CreateSnapshot(ctx context.Context, name string, snapshot string) (control.Snapshot, error) {
+	var ret api.CreateSnapshotSuccessResponse
+	err := cl.do(ctx, http.MethodPost, "/instances/"+seg(name)+"/snapshots", nil, api.CreateSnapshotBody{Name: snapshot}, &ret)
+	return ret, err
+}
+
+func (cl *Client) RestoreSnapshot(ctx context.Context, name string, snapshot string) (control.Instance, error) {
+	var ret api.RestoreSnapshotSuccessResponse
+	err := cl.do(ctx, http.MethodPost, "/instances/"+seg(name)+"/restore", nil, api.RestoreSnapshotBody{Snapshot: snapshot}, &ret)
+	return ret, err
+}
+
+func (cl *Client) ListSnapshots(ctx context.Context) ([]control.Snapshot, error) {
+	var ret api.ListSnapshotsSuccessResponse
+	err := cl.do(ctx, http.MethodGet, "/snapshots", nil, nil, &ret)
+	return ret, err
+}
+
+func (cl *Client) DeleteSnapshot(ctx context.Context, snapshot string) error {
+	return cl.do(ctx, http.MethodDelete, "/snapshots/"+seg(snapshot), nil, nil, nil)
+}
+
+func (cl *Client) CloneSnapshot(ctx context.Context, snapshot string, name string) (control.Instance, error) {
+	var ret api.CloneSnapshotSuccessResponse
+	err := cl.do(ctx, http.MethodPost, "/snapshots/"+seg(snapshot)+"/clone", nil, api.CloneSnapshotBody{Name: name}, &ret)
+	return ret, err
+}
@@ -0,0 +1,202 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"netpart/api"
+	"netpart/control"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The logs come back as plain text, one line per log line. Close the reader when done.
+// With opts.Follow the reader only ends when ctx is cancelled or the instance goes away.
+func (cl *Client) Logs(ctx context.Context, name string, opts control.LogOptions) (io.ReadCloser, error) {
+	query := url.Values{}
+	if opts.Follow {
+		query.Set("follow", "true")
+	}
+	if opts.Timestamps {
+		query.Set("timestamps", "true")
+	}
+	if opts.Since != "" {
+		query.Set("since", opts.Since)
+	}
+	if opts.Tail != "" {
+		query.Set("tail", opts.Tail)
+	}
+
+	res, err := cl.send(ctx, http.MethodGet, "/instances/"+seg(name)+"/logs", query, nil, http.Header{"Accept": {"text/plain"}})
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// Calls fn for every event after the given id until ctx is cancelled or fn returns an error.
+// Pass 0 to only get new events. Returns nil when ctx is cancelled.
+func (cl *Client) Events(ctx context.Context, after uint64, fn func(control.Event) error) error {
+	query := url.Values{}
+	if after != 0 {
+		query.Set("after", strconv.FormatUint(after, 10))
+	}
+
+	res, err := cl.send(ctx, http.MethodGet, "/events", query, nil, http.Header{"Accept": {"text/event-stream"}})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// events are only made of id and data lines, everything else is a heartbeat
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+
+		var e control.Event
+		err := json.Unmarshal([]byte(data), &e)
+		if err != nil {
+			return fmt.Errorf("decode event: %w", err)
+		}
+
+		err = fn(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// Terminal is a psql session on an instance.
+// Read returns what psql prints and io.EOF once it exits.
+type Terminal struct {
+	ws  *websocket.Conn
+	buf []byte
+}
+
+func (cl *Client) OpenTerminal(ctx context.Context, name string, cols uint, rows uint) (*Terminal, error) {
+	query := url.Values{
+		"cols": {strconv.FormatUint(uint64(cols), 10)},
+		"rows": {strconv.FormatUint(uint64(rows), 10)},
+	}
+	u := cl.url("/instances/"+seg(name)+"/terminal", query)
+	u = "ws" + strings.TrimPrefix(u, "http")
+
+	ws, res, err := websocket.DefaultDialer.DialContext(ctx, u, nil)
+	if err != nil {
+		if res != nil {
+			defer res.Body.Close()
+			return nil, readError(res)
+		}
+		return nil, err
+	}
+
+	return &Terminal{ws: ws}, nil
+}
+
+func (t *Terminal) Read(p []byte) (int, error) {
+	for len(t.buf) == 0 {
+		typ, data, err := t.ws.ReadMessage()
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		if typ == websocket.BinaryMessage {
+			t.buf = data
+		}
+	}
+
+	n := copy(p, t.buf)
+	t.buf = t.buf[n:]
+	return n, nil
+}
+
+func (t *Terminal) Write(p []byte) (int, error) {
+	err := t.ws.WriteJSON(api.TerminalMessage{Type: "input", Data: string(p)})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (t *Terminal) Resize(cols uint, rows uint) error {
+	return t.ws.WriteJSON(api.TerminalMessage{Type: "resize", Cols: cols, Rows: rows})
+}
+
+func (t *Terminal) Close() error {
+	t.ws.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+	return t.ws.Close()
+}
+
+// The capture runs in the background, see WaitCapture.
+func (cl *Client) StartCapture(ctx context.Context, name string, duration time.Duration, filter string) (control.Capture, error) {
+	var ret api.StartCaptureSuccessResponse
+	body := api.StartCaptureBody{DurationMs: duration.Milliseconds(), Filter: filter}
+	err := cl.do(ctx, http.MethodPost, "/instances/"+seg(name)+"/capture", nil, body, &ret)
+	return ret, err
+}
+
+func (cl *Client) ListCaptures(ctx context.Context) ([]control.Capture, error) {
+	var ret api.ListCapturesResponse
+	err := cl.do(ctx, http.MethodGet, "/captures", nil, nil, &ret)
+	return ret, err
+}
+
+func (cl *Client) GetCapture(ctx context.Context, id string) (control.Capture, error) {
+	var ret api.GetCaptureSuccessResponse
+	err := cl.do(ctx, http.MethodGet, "/captures/"+seg(id), nil, nil, &ret)
+	return ret, err
+}
+
+// Polls the capture until it's no longer running.
+func (cl *Client) WaitCapture(ctx context.Context, id string) (control.Capture, error) {
+	for {
+		capture, err := cl.GetCapture(ctx, id)
+		if err != nil {
+			return capture, err
+		}
+		if capture.State != control.CAPTURE_RUNNING {
+			return capture, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return capture, ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// Returns the pcap file of a finished capture. Close the reader when done.
+func (cl *Client) DownloadCapture(ctx context.Context, id string) (io.ReadCloser, error) {
+	res, err := cl.send(ctx, http.MethodGet, "/captures/"+seg(id)+"/pcap", nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (cl *Client) DeleteCapture(ctx context.Context, id string) error {
+	return cl.do(ctx, http.MethodDelete, "/captures/"+seg(id), nil, nil, nil)
+}
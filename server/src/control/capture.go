@@ -0,0 +1,330 @@
+package control
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+const DEFAULT_CAPTURE_DURATION = 10 * time.Second
+const MAX_CAPTURE_DURATION = 5 * time.Minute
+
+// where tcpdump writes inside the helper container
+const CAPTURE_PATH = "/tmp/capture.pcap"
+
+const (
+	CAPTURE_RUNNING = "running"
+	CAPTURE_DONE    = "done"
+	CAPTURE_FAILED  = "failed"
+)
+
+type Capture struct {
+	ID        string
+	Instance  string
+	Filter    string // a pcap filter expression, everything when empty
+	Duration  float64
+	StartedAt time.Time
+	State     string
+	Error     string
+	Size      int64 // of the pcap file once done
+}
+
+type captureStore struct {
+	mu       sync.Mutex
+	captures map[string]*Capture
+	dir      string
+}
+
+func (s *captureStore) get(id string) (Capture, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	capture, ok := s.captures[id]
+	if !ok {
+		return Capture{}, false
+	}
+	return *capture, true
+}
+
+func (s *captureStore) update(id string, fn func(*Capture)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if capture, ok := s.captures[id]; ok {
+		fn(capture)
+	}
+}
+
+func (s *captureStore) path(id string) string {
+	return filepath.Join(s.dir, id+".pcap")
+}
+
+// Starts capturing packets on the instance's own network, which carries its traffic with every instance that joined it.
+// Links where the instance is the one joining show up on the other instance's network instead.
+// The capture runs in the background using a helper image that has tcpdump, and can be downloaded once done.
+func (c *ControlPlane) StartCapture(ctx context.Context, inst Instance, image string, duration time.Duration, filter string) (Capture, error) {
+	if duration == 0 {
+		duration = DEFAULT_CAPTURE_DURATION
+	}
+	if duration < 0 || duration > MAX_CAPTURE_DURATION {
+		return Capture{}, errorf(ErrInvalid, "duration must be between 0 and %v", MAX_CAPTURE_DURATION)
+	}
+	if image == "" {
+		return Capture{}, fmt.Errorf("no capture image configured")
+	}
+
+	net, err := c.cli.NetworkInspect(ctx, inst.NetworkID, network.InspectOptions{})
+	if err != nil {
+		return Capture{}, err
+	}
+
+	bridge := net.Options["com.docker.network.bridge.name"]
+	if bridge == "" {
+		bridge = "br-" + shortID(net.ID)
+	}
+
+	err = c.ensureImage(ctx, image)
+	if err != nil {
+		return Capture{}, err
+	}
+
+	c.captures.mu.Lock()
+	if c.captures.captures == nil {
+		c.captures.captures = make(map[string]*Capture)
+		dir, err := os.MkdirTemp("", "netpart-captures-")
+		if err != nil {
+			c.captures.mu.Unlock()
+			return Capture{}, err
+		}
+		c.captures.dir = dir
+	}
+	c.captures.mu.Unlock()
+
+	cmd := []string{"-i", bridge, "-U", "-w", CAPTURE_PATH}
+	if filter != "" {
+		cmd = append(cmd, filter)
+	}
+
+	// the bridge only exists in the daemon's network namespace
+	ctr, err := c.cli.ContainerCreate(ctx, &container.Config{
+		Image:      image,
+		User:       "root",
+		Entrypoint: []string{"tcpdump"},
+		Cmd:        cmd,
+	}, &container.HostConfig{
+		NetworkMode: "host",
+		CapAdd:      []string{"NET_ADMIN", "NET_RAW"},
+	}, nil, nil, "")
+	if err != nil {
+		return Capture{}, err
+	}
+
+	waitC, errC := c.cli.ContainerWait(context.Background(), ctr.ID, container.WaitConditionNextExit)
+
+	err = c.cli.ContainerStart(ctx, ctr.ID, container.StartOptions{})
+	if err != nil {
+		c.cli.ContainerRemove(context.Background(), ctr.ID, container.RemoveOptions{Force: true})
+		return Capture{}, err
+	}
+
+	capture := &Capture{
+		ID:        newCaptureID(),
+		Instance:  inst.Name,
+		Filter:    filter,
+		Duration:  duration.Seconds(),
+		StartedAt: time.Now(),
+		State:     CAPTURE_RUNNING,
+	}
+
+	c.captures.mu.Lock()
+	c.captures.captures[capture.ID] = capture
+	ret := *capture
+	c.captures.mu.Unlock()
+
+	slog.InfoContext(ctx, "started capture", "op", "start_capture", "instance", inst, "capture", capture.ID, "bridge", bridge)
+
+	// outlives the request that started it
+	go c.finishCapture(context.Background(), capture.ID, ctr.ID, duration, waitC, errC)
+
+	return ret, nil
+}
+
+func (c *ControlPlane) finishCapture(ctx context.Context, id string, containerID string, duration time.Duration, waitC <-chan container.WaitResponse, errC <-chan error) {
+	defer c.cli.ContainerRemove(ctx, containerID, container.RemoveOptions{Force: true})
+
+	fail := func(err error) {
+		slog.WarnContext(ctx, "capture failed", "op", "finish_capture", "capture", id, "err", err)
+		c.captures.update(id, func(capture *Capture) {
+			capture.State = CAPTURE_FAILED
+			capture.Error = err.Error()
+		})
+	}
+
+	// tcpdump quitting early means it didn't like the filter or the interface
+	early := true
+	select {
+	case <-time.After(duration):
+		early = false
+	case <-waitC:
+	case err := <-errC:
+		fail(err)
+		return
+	}
+
+	if !early {
+		// lets tcpdump flush what it has
+		err := c.cli.ContainerKill(ctx, containerID, "SIGINT")
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		select {
+		case <-waitC:
+		case err := <-errC:
+			fail(err)
+			return
+		}
+	}
+
+	if early {
+		logs, _ := c.readLogs(ctx, Instance{ContainerID: containerID}, "20")
+		fail(fmt.Errorf("tcpdump exited early: %v", logs))
+		return
+	}
+
+	size, err := c.copyCapture(ctx, containerID, c.captures.path(id))
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	c.captures.update(id, func(capture *Capture) {
+		capture.State = CAPTURE_DONE
+		capture.Size = size
+	})
+	slog.InfoContext(ctx, "finished capture", "op", "finish_capture", "capture", id, "size", size)
+}
+
+// Copies the pcap out of the helper container, docker hands it over as a tar archive.
+func (c *ControlPlane) copyCapture(ctx context.Context, containerID string, dst string) (int64, error) {
+	rc, _, err := c.cli.CopyFromContainer(ctx, containerID, CAPTURE_PATH)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	_, err = tr.Next()
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return io.Copy(f, tr)
+}
+
+func (c *ControlPlane) ensureImage(ctx context.Context, ref string) error {
+	_, err := c.cli.ImageInspect(ctx, ref)
+	if err == nil {
+		return nil
+	}
+	if !client.IsErrNotFound(err) {
+		return err
+	}
+
+	rc, err := c.cli.ImagePull(ctx, ref, image.PullOptions{})
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// the pull is done once the progress stream ends
+	_, err = io.Copy(io.Discard, rc)
+	return err
+}
+
+func newCaptureID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (c *ControlPlane) GetCapture(id string) (Capture, error) {
+	capture, ok := c.captures.get(id)
+	if !ok {
+		return Capture{}, errorf(ErrNotFound, "cannot find capture %v", id)
+	}
+	return capture, nil
+}
+
+func (c *ControlPlane) ListCaptures() []Capture {
+	c.captures.mu.Lock()
+	defer c.captures.mu.Unlock()
+
+	ret := make([]Capture, 0, len(c.captures.captures))
+	for _, capture := range c.captures.captures {
+		ret = append(ret, *capture)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].StartedAt.Before(ret[j].StartedAt)
+	})
+
+	return ret
+}
+
+// Opens the pcap of a finished capture.
+func (c *ControlPlane) OpenCapture(id string) (*os.File, error) {
+	capture, err := c.GetCapture(id)
+	if err != nil {
+		return nil, err
+	}
+	if capture.State != CAPTURE_DONE {
+		return nil, errorf(ErrConflict, "capture %v is %v", id, capture.State)
+	}
+
+	return os.Open(c.captures.path(id))
+}
+
+// Forgets a finished capture along with its pcap.
+func (c *ControlPlane) DeleteCapture(id string) error {
+	capture, err := c.GetCapture(id)
+	if err != nil {
+		return err
+	}
+	if capture.State == CAPTURE_RUNNING {
+		return errorf(ErrConflict, "capture %v is still running", id)
+	}
+
+	c.captures.mu.Lock()
+	delete(c.captures.captures, id)
+	c.captures.mu.Unlock()
+
+	err = os.Remove(c.captures.path(id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	slog.Info("deleted capture", "op", "delete_capture", "capture", id)
+	return nil
+}
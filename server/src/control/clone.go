@@ -0,0 +1,36 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Creates a new instance holding a copy of the source's data.
+// The new instance gets its tables from the current schema, which should match the source's.
+// The data is dumped from the running source and piped through the control plane,
+// so the source doesn't have to be stopped or connected to the new instance.
+func (c *ControlPlane) CloneInstance(ctx context.Context, source Instance, name string, image string, opts ...InstanceOption) (Instance, error) {
+	inst, err := c.AddInstance(ctx, name, image, opts...)
+	if err != nil {
+		return Instance{}, err
+	}
+
+	err = c.pipe(ctx,
+		source.ContainerID,
+		[]string{"pg_dump", "-U", POSTGRES_USER, "-d", POSTGRES_DB, "--data-only"},
+		inst.ContainerID,
+		[]string{"psql", "-U", POSTGRES_USER, "-d", POSTGRES_DB, "-q", "-v", "ON_ERROR_STOP=1"},
+	)
+	if err != nil {
+		// a clone with part of the data is no use to anyone
+		killErr := c.KillInstance(ctx, inst, false)
+		if killErr != nil {
+			slog.WarnContext(ctx, "cannot remove failed clone", "op", "clone_instance", "instance", inst, "err", killErr)
+		}
+		return Instance{}, fmt.Errorf("cannot copy data from %v: %w", source.Name, err)
+	}
+
+	slog.InfoContext(ctx, "cloned instance", "op", "clone_instance", "instance", inst, "source", source)
+	return inst, nil
+}
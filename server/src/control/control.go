@@ -3,19 +3,30 @@ package control
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/go-connections/nat"
 )
 
 const PREFIX = "netpart-"
 
+// where the postgres image keeps its data
+const PGDATA = "/var/lib/postgresql/data"
+
+// marks volumes that hold an instance's PGDATA
+const DATA_LABEL = "netpart.data"
+
 var ENVS = [3]string{
 	"POSTGRES_USER=" + POSTGRES_USER,
 	"POSTGRES_PASSWORD=" + POSTGRES_PASSWORD,
@@ -27,14 +38,23 @@ type Instance struct {
 	ContainerID string
 	NetworkID   string
 	Port        string
+	State       string
+	Volume      string
 }
 
 type ControlPlane struct {
 	cli *client.Client
+
+	mu     sync.Mutex
+	schema Schema
+
+	events   eventBus
+	traffic  trafficTracker
+	captures captureStore
 }
 
 func MakeControlPlane(ctx context.Context, ops ...client.Opt) (*ControlPlane, error) {
-	cli, err := client.NewClientWithOpts(ops...)
+	cli, err := client.NewClientWithOpts(append(ops, withDockerMetrics())...)
 	if err != nil {
 		return nil, err
 	}
@@ -42,23 +62,83 @@ func MakeControlPlane(ctx context.Context, ops ...client.Opt) (*ControlPlane, er
 	for {
 		_, err := cli.Ping(ctx)
 		if err != nil {
-			fmt.Println(err)
+			slog.WarnContext(ctx, "docker daemon unreachable, retrying", "err", err)
 			time.Sleep(500 * time.Millisecond)
 		} else {
-			fmt.Println("docker daemon connected!")
+			slog.InfoContext(ctx, "docker daemon connected")
 			break
 		}
 	}
 
 	c := &ControlPlane{
-		cli: cli,
+		cli:    cli,
+		schema: DefaultSchema,
 	}
 
 	return c, nil
 }
 
-func (c *ControlPlane) AddInstance(ctx context.Context, name string, image string) (Instance, error) {
-	name = PREFIX + name
+// InstanceOption customizes how AddInstance provisions a new instance.
+type InstanceOption func(*instanceConfig)
+
+type instanceConfig struct {
+	parameters map[string]string
+	resources  Resources
+	snapshot   *Snapshot
+}
+
+// WithParameters passes each GUC to postgres as a -c flag on startup.
+func WithParameters(params map[string]string) InstanceOption {
+	return func(cfg *instanceConfig) {
+		cfg.parameters = params
+	}
+}
+
+// WithResources limits the cpu, memory and block io of the instance's container.
+func WithResources(res Resources) InstanceOption {
+	return func(cfg *instanceConfig) {
+		cfg.resources = res
+	}
+}
+
+// FromSnapshot starts the instance with a copy of the snapshot's data.
+// Replication carried over from the snapshotted instance is dropped.
+func FromSnapshot(snap Snapshot) InstanceOption {
+	return func(cfg *instanceConfig) {
+		cfg.snapshot = &snap
+	}
+}
+
+func (c *ControlPlane) AddInstance(ctx context.Context, name string, image string, opts ...InstanceOption) (Instance, error) {
+	err := ValidateName(name)
+	if err != nil {
+		return Instance{}, err
+	}
+	name = FullName(name)
+
+	// checked before anything is created, the conflict on ContainerCreate below only catches races
+	_, err = c.cli.ContainerInspect(ctx, name)
+	if err == nil {
+		return Instance{}, errorf(ErrAlreadyExists, "instance %v already exists", name)
+	}
+	if !errdefs.IsNotFound(err) {
+		return Instance{}, err
+	}
+
+	var cfg instanceConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	err = ValidateParameters(cfg.parameters)
+	if err != nil {
+		return Instance{}, err
+	}
+
+	err = ValidateResources(cfg.resources)
+	if err != nil {
+		return Instance{}, err
+	}
 
 	portMap := nat.PortMap{
 		"5432/tcp": []nat.PortBinding{
@@ -69,19 +149,63 @@ func (c *ControlPlane) AddInstance(ctx context.Context, name string, image strin
 		},
 	}
 
+	// reuses the volume if the instance was killed with its data kept
+	_, err = c.cli.VolumeInspect(ctx, name)
+	keptVolume := err == nil
+
+	vol, err := c.cli.VolumeCreate(ctx, volume.CreateOptions{
+		Name: name,
+		Labels: map[string]string{
+			DATA_LABEL: "true",
+		},
+	})
+	if err != nil {
+		return Instance{}, err
+	}
+
+	// filled in as the parts are made, so a failure below removes what was made so far
+	inst := Instance{Name: name, Volume: vol.Name}
+	added := false
+	defer func() {
+		if !added {
+			c.discardInstance(ctx, inst, keptVolume)
+		}
+	}()
+
+	if cfg.snapshot != nil {
+		err = c.copyVolume(ctx, image, cfg.snapshot.Volume, vol.Name)
+		if err != nil {
+			return Instance{}, err
+		}
+	}
+
 	hostConfig := &container.HostConfig{
 		PortBindings: portMap,
+		Resources:    cfg.resources.toDocker(),
+		Mounts: []mount.Mount{
+			{
+				Type:   mount.TypeVolume,
+				Source: vol.Name,
+				Target: PGDATA,
+			},
+		},
 	}
 
 	ctr, err := c.cli.ContainerCreate(ctx, &container.Config{
 		Image: image,
 		Env:   ENVS[:],
-		Cmd:   []string{"postgres", "-c", "wal_level=logical"},
+		Cmd:   postgresCmd(cfg.parameters),
 	}, hostConfig, nil, nil, name)
 
+	if errdefs.IsConflict(err) {
+		// the container is someone else's, and so is the volume
+		keptVolume = true
+		return Instance{}, errorf(ErrAlreadyExists, "instance %v already exists", name)
+	}
 	if err != nil {
 		return Instance{}, err
 	}
+	inst.ContainerID = ctr.ID
 
 	err = c.cli.ContainerStart(ctx, ctr.ID, container.StartOptions{})
 
@@ -94,56 +218,103 @@ func (c *ControlPlane) AddInstance(ctx context.Context, name string, image strin
 		return Instance{}, err
 	}
 
-	portInfo := inspect.NetworkSettings.Ports["5432/tcp"][0].HostPort
-	if portInfo == "" {
+	// a container that already exited has no ports
+	bindings := inspect.NetworkSettings.Ports["5432/tcp"]
+	if len(bindings) == 0 || bindings[0].HostPort == "" {
 		return Instance{}, fmt.Errorf("failed to bind instance port for %v", name)
 	}
+	portInfo := bindings[0].HostPort
 
-	fmt.Printf("started container %v at port %v\n", name, portInfo)
+	slog.InfoContext(ctx, "started container", "op", "add_instance", "instance", name, "container", shortID(ctr.ID), "port", portInfo)
 
 	net, err := c.cli.NetworkCreate(ctx, name, network.CreateOptions{})
 
 	if err != nil {
 		return Instance{}, err
 	}
+	inst.NetworkID = net.ID
 
 	err = c.cli.NetworkConnect(ctx, net.ID, ctr.ID, nil)
 	if err != nil {
 		return Instance{}, err
 	}
 
-	inst := Instance{
-		ContainerID: ctr.ID,
-		NetworkID:   net.ID,
-		Name:        name,
-		Port:        portInfo,
-	}
+	inst.Port = portInfo
+	inst.State = inspect.State.Status
 
-	err = SetupDB(ctx, inst)
+	err = c.waitStarted(ctx, inst)
 	if err != nil {
 		return Instance{}, err
 	}
 
+	// a snapshot brings its own tables
+	if cfg.snapshot != nil {
+		err = detachReplication(ctx, inst)
+		if err != nil {
+			return Instance{}, err
+		}
+	} else {
+		err = SetupDB(ctx, inst, c.Schema())
+		if err != nil {
+			return Instance{}, err
+		}
+	}
+
+	added = true
+	c.publish(EVENT_INSTANCE_ADDED, fmt.Sprintf("added %v", inst.Name), inst)
 	return inst, nil
 }
 
+// Removes the parts of an instance that AddInstance made before failing.
+// A volume that was there before holds kept data, so it stays.
+func (c *ControlPlane) discardInstance(ctx context.Context, inst Instance, keepVolume bool) {
+	// the request that failed may be cancelled, the cleanup still has to run
+	ctx = context.WithoutCancel(ctx)
+
+	if inst.ContainerID != "" {
+		err := c.cli.ContainerRemove(ctx, inst.ContainerID, container.RemoveOptions{Force: true})
+		if err != nil {
+			slog.WarnContext(ctx, "cannot remove container", "op", "add_instance", "instance", inst.Name, "err", err)
+		}
+	}
+
+	if inst.NetworkID != "" {
+		err := c.cli.NetworkRemove(ctx, inst.NetworkID)
+		if err != nil {
+			slog.WarnContext(ctx, "cannot remove network", "op", "add_instance", "instance", inst.Name, "err", err)
+		}
+	}
+
+	if !keepVolume {
+		err := c.cli.VolumeRemove(ctx, inst.Volume, false)
+		if err != nil {
+			slog.WarnContext(ctx, "cannot remove volume", "op", "add_instance", "instance", inst.Name, "err", err)
+		}
+	}
+
+	slog.InfoContext(ctx, "discarded instance", "op", "add_instance", "instance", inst.Name)
+}
+
 func (c *ControlPlane) GetInstance(ctx context.Context, name string) (Instance, error) {
 	insts, err := c.ListInstances(ctx)
 	if err != nil {
 		return Instance{}, err
 	}
 
+	name = FullName(name)
 	for _, c := range insts {
 		if c.Name == name {
 			return c, nil
 		}
 	}
 
-	return Instance{}, fmt.Errorf("cannot find instance %v", name)
+	return Instance{}, errorf(ErrNotFound, "cannot find instance %v", name)
 }
 
 func (c *ControlPlane) ListInstances(ctx context.Context) ([]Instance, error) {
-	containers, err := c.cli.ContainerList(ctx, container.ListOptions{})
+	containers, err := c.cli.ContainerList(ctx, container.ListOptions{
+		All: true,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -160,11 +331,24 @@ func (c *ControlPlane) ListInstances(ctx context.Context) ([]Instance, error) {
 			continue
 		}
 
-		lkp[name] = &Instance{
+		inst := &Instance{
 			Name:        name,
 			ContainerID: c.ID,
-			Port:        fmt.Sprint(c.Ports[0].PublicPort),
+			State:       c.State,
 		}
+		for _, m := range c.Mounts {
+			if m.Destination == PGDATA {
+				inst.Volume = m.Name
+			}
+		}
+		// ports are only published while the container is running
+		for _, p := range c.Ports {
+			if p.PublicPort != 0 {
+				inst.Port = fmt.Sprint(p.PublicPort)
+				break
+			}
+		}
+		lkp[name] = inst
 	}
 
 	for _, n := range networks {
@@ -174,15 +358,19 @@ func (c *ControlPlane) ListInstances(ctx context.Context) ([]Instance, error) {
 
 		inst := lkp[n.Name]
 		if inst == nil {
-			panic(fmt.Errorf("found unused network: %v (%v)", n.Name, n.ID))
+			slog.DebugContext(ctx, "skipping network without container", "network", n.Name)
+			continue
 		}
-		lkp[n.Name].NetworkID = n.ID
+		inst.NetworkID = n.ID
 	}
 
+	// AddInstance makes the container before the network, and KillInstance removes it before the network,
+	// so an instance that is half made or half removed is left out until it's done
 	ret := make([]Instance, 0)
 	for _, c := range lkp {
 		if c.NetworkID == "" {
-			panic(fmt.Errorf("found unconnected container: %v (%v)", c.Name, c.ContainerID))
+			slog.DebugContext(ctx, "skipping container without network", "instance", c.Name)
+			continue
 		}
 		ret = append(ret, *c)
 	}
@@ -194,25 +382,41 @@ func (c *ControlPlane) ListInstances(ctx context.Context) ([]Instance, error) {
 	return ret, nil
 }
 
-func (c *ControlPlane) KillInstance(ctx context.Context, inst Instance) error {
+// Removes the instance's container and network.
+// The data volume is removed too, unless keepVolume is set so a new instance with the same name can pick it up.
+func (c *ControlPlane) KillInstance(ctx context.Context, inst Instance, keepVolume bool) error {
 	err := c.cli.ContainerRemove(ctx, inst.ContainerID, container.RemoveOptions{
-		Force: true,
+		Force:         true,
+		RemoveVolumes: true,
 	})
 	if err != nil {
 		return err
 	}
-	fmt.Printf("killed container %v\n", inst.Name)
+	slog.InfoContext(ctx, "killed container", "op", "kill_instance", "instance", inst)
 
 	err = c.cli.NetworkRemove(ctx, inst.NetworkID)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("killed network %v\n", inst.Name)
+	slog.InfoContext(ctx, "killed network", "op", "kill_instance", "instance", inst)
+	c.publish(EVENT_INSTANCE_KILLED, fmt.Sprintf("killed %v", inst.Name), inst)
+
+	if keepVolume || inst.Volume == "" {
+		return nil
+	}
+
+	err = c.cli.VolumeRemove(ctx, inst.Volume, false)
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "killed volume", "op", "kill_instance", "instance", inst, "volume", inst.Volume)
 	return nil
 }
 
-func (c *ControlPlane) Cleanup(ctx context.Context) error {
+// Removes every container and network made by netpart, along with the data volumes unless keepVolumes is set.
+func (c *ControlPlane) Cleanup(ctx context.Context, keepVolumes bool) error {
 	containers, err := c.cli.ContainerList(ctx, container.ListOptions{
 		All: true,
 	})
@@ -233,7 +437,8 @@ func (c *ControlPlane) Cleanup(ctx context.Context) error {
 		go func() {
 			defer wg.Done()
 			err := c.cli.ContainerRemove(ctx, cont.ID, container.RemoveOptions{
-				Force: true,
+				Force:         true,
+				RemoveVolumes: true,
 			})
 			if err != nil {
 				errs <- err
@@ -263,6 +468,30 @@ func (c *ControlPlane) Cleanup(ctx context.Context) error {
 		}()
 	}
 
+	if !keepVolumes {
+		volumes, err := c.cli.VolumeList(ctx, volume.ListOptions{
+			Filters: filters.NewArgs(filters.Arg("label", DATA_LABEL)),
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, vol := range volumes.Volumes {
+			if !strings.HasPrefix(vol.Name, PREFIX) {
+				continue
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := c.cli.VolumeRemove(ctx, vol.Name, false)
+				if err != nil {
+					errs <- err
+				}
+			}()
+		}
+	}
+
 	go func() {
 		wg.Wait()
 		close(errs)
@@ -272,7 +501,7 @@ func (c *ControlPlane) Cleanup(ctx context.Context) error {
 		return err
 	}
 
-	fmt.Println("containers and networks cleaned...")
+	slog.InfoContext(ctx, "containers, networks, and volumes cleaned", "op", "cleanup")
 	return nil
 }
 
@@ -318,7 +547,8 @@ func (c *ControlPlane) Connect(ctx context.Context, inst1 Instance, inst2 Instan
 	if res != nil {
 		return res
 	}
-	fmt.Printf("connected %v to %v\n", lower.Name, higher.Name)
+	slog.InfoContext(ctx, "connected instances", "op", "connect", "instance", lower, "peer", higher)
+	c.publish(EVENT_LINK_CONNECTED, fmt.Sprintf("connected %v to %v", lower.Name, higher.Name), lower, higher)
 	return nil
 }
 
@@ -338,6 +568,23 @@ func (c *ControlPlane) Disconnect(ctx context.Context, inst1 Instance, inst2 Ins
 	if res != nil {
 		return res
 	}
-	fmt.Printf("disconnected %v from %v\n", lower.Name, higher.Name)
+	slog.InfoContext(ctx, "disconnected instances", "op", "disconnect", "instance", lower, "peer", higher)
+	c.publish(EVENT_LINK_DISCONNECTED, fmt.Sprintf("disconnected %v from %v", lower.Name, higher.Name), lower, higher)
 	return nil
 }
+
+// how docker shortens ids
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// Instances show up in log lines with the name and container they can be found by.
+func (inst Instance) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("name", inst.Name),
+		slog.String("container", shortID(inst.ContainerID)),
+	)
+}
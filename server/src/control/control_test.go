@@ -1,14 +1,19 @@
 package control_test
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"netpart/control"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 )
 
 var c *control.ControlPlane
@@ -19,7 +24,7 @@ func TestMain(m *testing.M) {
 	if err != nil {
 		panic(err)
 	}
-	err = nc.Cleanup(ctx)
+	err = nc.Cleanup(ctx, false)
 	if err != nil {
 		panic(err)
 	}
@@ -33,14 +38,14 @@ func TestMain(m *testing.M) {
 
 func TestCleanup(t *testing.T) {
 	ctx := context.Background()
-	c.Cleanup(ctx)
+	c.Cleanup(ctx, false)
 	c.ListInstances(ctx)
 }
 
 func TestProvision(t *testing.T) {
 	ctx := context.Background()
 
-	err := c.Cleanup(ctx)
+	err := c.Cleanup(ctx, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -74,7 +79,7 @@ func TestProvision(t *testing.T) {
 	})
 
 	t.Run("can delete instance", func(t *testing.T) {
-		err := c.KillInstance(ctx, inst)
+		err := c.KillInstance(ctx, inst, false)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -84,7 +89,7 @@ func TestProvision(t *testing.T) {
 func TestConnection(t *testing.T) {
 	ctx := context.Background()
 
-	err := c.Cleanup(ctx)
+	err := c.Cleanup(ctx, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -121,7 +126,7 @@ func TestConnection(t *testing.T) {
 func TestDatabase(t *testing.T) {
 	ctx := context.Background()
 
-	err := c.Cleanup(ctx)
+	err := c.Cleanup(ctx, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -148,7 +153,7 @@ func TestDatabase(t *testing.T) {
 func TestReplication(t *testing.T) {
 	ctx := context.Background()
 
-	err := c.Cleanup(ctx)
+	err := c.Cleanup(ctx, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -168,12 +173,12 @@ func TestReplication(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = control.SetupPrimary(ctx, active)
+	err = c.SetupPrimary(ctx, active, control.DefaultSchema)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = control.SetupStandby(ctx, passive, active)
+	err = c.SetupStandby(ctx, passive, active)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -220,7 +225,7 @@ func TestReplication(t *testing.T) {
 func TestDisconnection(t *testing.T) {
 	ctx := context.Background()
 
-	err := c.Cleanup(ctx)
+	err := c.Cleanup(ctx, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -240,12 +245,12 @@ func TestDisconnection(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = control.SetupPrimary(ctx, active)
+	err = c.SetupPrimary(ctx, active, control.DefaultSchema)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = control.SetupStandby(ctx, passive, active)
+	err = c.SetupStandby(ctx, passive, active)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -281,7 +286,7 @@ func TestDisconnection(t *testing.T) {
 func TestRestart(t *testing.T) {
 	ctx := context.Background()
 
-	err := c.Cleanup(ctx)
+	err := c.Cleanup(ctx, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -301,22 +306,1651 @@ func TestRestart(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = control.SetupPrimary(ctx, active)
+	err = c.SetupPrimary(ctx, active, control.DefaultSchema)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = control.SetupStandby(ctx, passive, active)
+	err = c.SetupStandby(ctx, passive, active)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = control.RestartStandby(ctx, passive, active)
+	err = c.RestartStandby(ctx, passive, active)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
+func TestParameters(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inst, err := c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"), control.WithParameters(map[string]string{
+		"max_wal_senders":    "5",
+		"wal_sender_timeout": "10s",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("applies parameters on startup", func(t *testing.T) {
+		err := findParam(inst, "max_wal_senders", "5")
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("reloads parameters", func(t *testing.T) {
+		err := control.SetParameters(ctx, inst, map[string]string{
+			"wal_sender_timeout": "20s",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = findParam(inst, "wal_sender_timeout", "20000")
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("rejects parameters that need a restart", func(t *testing.T) {
+		err := control.SetParameters(ctx, inst, map[string]string{
+			"max_wal_senders": "8",
+		})
+		if err == nil {
+			t.Fatal("changed a postmaster parameter without restarting")
+		}
+	})
+
+	t.Run("rejects reserved parameters", func(t *testing.T) {
+		err := control.ValidateParameters(map[string]string{
+			"wal_level": "replica",
+		})
+		if err == nil {
+			t.Fatal("accepted a reserved parameter")
+		}
+	})
+
+	t.Run("fails fast when postgres can't start", func(t *testing.T) {
+		cli, err := client.NewClientWithOpts(client.FromEnv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cli.Close()
+
+		for i, params := range []map[string]string{{"foo": "1"}, {"max_wal_senders": "abc"}} {
+			ctx, cancel := context.WithTimeout(ctx, time.Minute)
+			defer cancel()
+
+			name := fmt.Sprintf("bad%v", i)
+			_, err := c.AddInstance(ctx, name, os.Getenv("POSTGRES_IMAGE"), control.WithParameters(params))
+			if !errors.Is(err, control.ErrInvalid) {
+				t.Fatalf("expected invalid for %v, got %v", params, err)
+			}
+
+			_, err = c.GetInstance(ctx, name)
+			if !errors.Is(err, control.ErrNotFound) {
+				t.Fatalf("expected %v to be removed, got %v", name, err)
+			}
+
+			_, err = cli.VolumeInspect(ctx, control.PREFIX+name)
+			if !errdefs.IsNotFound(err) {
+				t.Fatalf("expected the volume of %v to be removed, got %v", name, err)
+			}
+		}
+	})
+}
+
+func TestResources(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inst, err := c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"), control.WithResources(control.Resources{
+		CPUs:   0.5,
+		Memory: 256 * 1024 * 1024,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("applies limits on creation", func(t *testing.T) {
+		res, err := c.GetResources(ctx, inst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.CPUs != 0.5 || res.Memory != 256*1024*1024 {
+			t.Fatalf("unexpected limits %+v", res)
+		}
+	})
+
+	t.Run("updates limits live", func(t *testing.T) {
+		err := c.UpdateResources(ctx, inst, control.Resources{
+			CPUs: 0.25,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := c.GetResources(ctx, inst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.CPUs != 0.25 || res.Memory != 256*1024*1024 {
+			t.Fatalf("unexpected limits %+v", res)
+		}
+	})
+}
+
+func TestProcessFaults(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inst, err := c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in_key := "test"
+	in_value := "val"
+
+	err = control.Put(ctx, inst, in_key, in_value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("can pause and unpause", func(t *testing.T) {
+		err := c.PauseInstance(ctx, inst)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		paused, err := c.GetInstance(ctx, inst.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if paused.State != "paused" {
+			t.Fatalf("expected paused instance, got %v", paused.State)
+		}
+
+		err = c.UnpauseInstance(ctx, inst)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("survives a crash", func(t *testing.T) {
+		err := c.SignalInstance(ctx, inst, "SIGKILL")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(1 * time.Second)
+
+		crashed, err := c.GetInstance(ctx, inst.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if crashed.State != "exited" {
+			t.Fatalf("expected exited instance, got %v", crashed.State)
+		}
+
+		restarted, err := c.RestartInstance(ctx, crashed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restarted.NetworkID != inst.NetworkID {
+			t.Fatalf("instance lost its network on restart")
+		}
+
+		err = findVal(restarted, in_key, in_value)
+		if err != nil {
+			t.Fatal("failed to find data after restart")
+		}
+	})
+}
+
+func TestVolume(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inst, err := c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in_key := "test"
+	in_value := "val"
+
+	err = control.Put(ctx, inst, in_key, in_value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("keeps data when recreated", func(t *testing.T) {
+		err := c.KillInstance(ctx, inst, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		inst, err = c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = findVal(inst, in_key, in_value)
+		if err != nil {
+			t.Fatal("failed to find data after recreating")
+		}
+	})
+
+	t.Run("drops data when killed", func(t *testing.T) {
+		err := c.KillInstance(ctx, inst, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		inst, err = c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = findVal(inst, in_key, in_value)
+		if err == nil {
+			t.Fatal("data survived killing the volume")
+		}
+	})
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inst, err := c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in_key := "test"
+	in_value := "val"
+
+	err = control.Put(ctx, inst, in_key, in_value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := c.CreateSnapshot(ctx, inst, "known")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteSnapshot(ctx, snap)
+
+	t.Run("restores a snapshot", func(t *testing.T) {
+		inst, err := c.GetInstance(ctx, inst.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = control.Put(ctx, inst, in_key, "changed")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		inst, err = c.RestoreSnapshot(ctx, inst, snap)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = findVal(inst, in_key, in_value)
+		if err != nil {
+			t.Fatal("failed to find snapshotted data after restoring")
+		}
+	})
+
+	t.Run("clones a snapshot", func(t *testing.T) {
+		clone, err := c.AddInstance(ctx, "db2", os.Getenv("POSTGRES_IMAGE"), control.FromSnapshot(snap))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = findVal(clone, in_key, in_value)
+		if err != nil {
+			t.Fatal("failed to find snapshotted data on clone")
+		}
+	})
+}
+
+func TestClone(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	active, err := c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SetupPrimary(ctx, active, control.DefaultSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in_key := "test"
+	in_value := "val"
+
+	err = control.Put(ctx, active, in_key, in_value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passive, err := c.CloneInstance(ctx, active, "db2", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = findVal(passive, in_key, in_value)
+	if err != nil {
+		t.Fatal("failed to find data on clone")
+	}
+
+	t.Run("clone can follow without copying", func(t *testing.T) {
+		err := c.Connect(ctx, active, passive)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = c.SetupStandby(ctx, passive, active, control.WithoutCopy())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = control.Put(ctx, active, "new", in_value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(1 * time.Second)
+
+		err = findVal(passive, "new", in_value)
+		if err != nil {
+			t.Fatal("failed to find new data on clone")
+		}
+
+		rep, err := control.GetReplicationData(ctx, passive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, sub := range rep.StandbyData {
+			if !sub.Subenabled {
+				t.Fatalf("subscription %v got disabled", sub.Subname)
+			}
+		}
+	})
+
+	t.Run("failed clone is removed", func(t *testing.T) {
+		// pg_dump can't run in a paused container
+		err := c.PauseInstance(ctx, active)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.UnpauseInstance(ctx, active)
+
+		_, err = c.CloneInstance(ctx, active, "db3", os.Getenv("POSTGRES_IMAGE"))
+		if err == nil {
+			t.Fatal("cloned a paused instance")
+		}
+
+		_, err = c.GetInstance(ctx, "db3")
+		if !errors.Is(err, control.ErrNotFound) {
+			t.Fatalf("expected the failed clone to be removed, got %v", err)
+		}
+	})
+}
+
+func TestSubscriptionLifecycle(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{
+		"db1", "db2", "db3",
+	}
+
+	instances := make([]control.Instance, len(names))
+
+	for i, name := range names {
+		inst, err := c.AddInstance(ctx, name, os.Getenv("POSTGRES_IMAGE"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		instances[i] = inst
+	}
+
+	old_active, passive, new_active := instances[0], instances[1], instances[2]
+
+	for _, inst := range []control.Instance{old_active, new_active} {
+		err = c.Connect(ctx, inst, passive)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = c.SetupPrimary(ctx, inst, control.DefaultSchema)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = c.SetupStandby(ctx, passive, old_active)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("can disable standby", func(t *testing.T) {
+		err := c.DisableStandby(ctx, passive, old_active)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = c.RestartStandby(ctx, passive, old_active)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("can repoint standby", func(t *testing.T) {
+		err := c.RepointStandby(ctx, passive, old_active, new_active)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = control.Put(ctx, new_active, "test", "val")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(1 * time.Second)
+
+		err = findVal(passive, "test", "val")
+		if err != nil {
+			t.Fatal("failed to find data from new primary")
+		}
+	})
+
+	t.Run("can refresh standby", func(t *testing.T) {
+		err := control.RefreshStandby(ctx, passive, new_active)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("can drop standby", func(t *testing.T) {
+		err := c.DropStandby(ctx, passive, new_active)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rep, err := control.GetReplicationData(ctx, passive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rep.StandbyData) != 0 {
+			t.Fatalf("subscription still exists after dropping")
+		}
+	})
+}
+
+func TestSlots(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	active, err := c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passive, err := c.AddInstance(ctx, "db2", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Connect(ctx, active, passive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SetupPrimary(ctx, active, control.DefaultSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SetupStandby(ctx, passive, active)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1 * time.Second)
+
+	slots, err := control.ListSlots(ctx, active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slots) != 1 {
+		t.Fatalf("expected a slot for the standby, got %v", len(slots))
+	}
+
+	t.Run("refuses to drop slots in use", func(t *testing.T) {
+		err := control.DropSlot(ctx, active, slots[0].Slot_Name, false)
+		if err == nil {
+			t.Fatal("dropped a slot in use")
+		}
+	})
+
+	t.Run("drops orphaned slots", func(t *testing.T) {
+		err := c.KillInstance(ctx, passive, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = control.DropSlot(ctx, active, slots[0].Slot_Name, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		slots, err := control.ListSlots(ctx, active)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(slots) != 0 {
+			t.Fatalf("slot still exists after dropping")
+		}
+	})
+}
+
+func TestSubscriptionErrors(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	active, err := c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passive, err := c.AddInstance(ctx, "db2", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Connect(ctx, active, passive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SetupPrimary(ctx, active, control.DefaultSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SetupStandby(ctx, passive, active)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// let the initial copy finish first
+	time.Sleep(1 * time.Second)
+
+	// written on both sides, so applying the primary's insert conflicts
+	err = control.Put(ctx, passive, "test", "passive")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = control.Put(ctx, active, "test", "active")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	subs, err := c.GetSubscriptions(ctx, passive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 1 {
+		t.Fatalf("expected one subscription, got %v", len(subs))
+	}
+
+	sub := subs[0]
+
+	t.Run("surfaces conflicts", func(t *testing.T) {
+		if sub.Subenabled {
+			t.Fatal("subscription still enabled after conflict")
+		}
+		if sub.Last_Error == nil || !sub.Last_Error.Conflict {
+			t.Fatalf("conflict not found, got %+v", sub.Last_Error)
+		}
+	})
+
+	t.Run("skips conflicting transaction", func(t *testing.T) {
+		err := c.SkipTransaction(ctx, passive, sub.Subname, sub.Last_Error.Finish_Lsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = control.Put(ctx, active, "other", "val")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(1 * time.Second)
+
+		err = findVal(passive, "other", "val")
+		if err != nil {
+			t.Fatal("standby did not resume after skipping")
+		}
+
+		err = findVal(passive, "test", "passive")
+		if err != nil {
+			t.Fatal("standby lost its own value")
+		}
+	})
+}
+
+func TestMultiMaster(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{
+		"db1", "db2", "db3",
+	}
+
+	instances := make([]control.Instance, len(names))
+
+	for i, name := range names {
+		inst, err := c.AddInstance(ctx, name, os.Getenv("POSTGRES_IMAGE"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		instances[i] = inst
+	}
+
+	for i, inst1 := range instances {
+		for _, inst2 := range instances[i+1:] {
+			err := c.Connect(ctx, inst1, inst2)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	err = c.SetupMultiMaster(ctx, instances)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("replicates writes from every instance", func(t *testing.T) {
+		for i, inst := range instances {
+			err := control.Put(ctx, inst, fmt.Sprint("key", i), inst.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		time.Sleep(2 * time.Second)
+
+		for _, inst := range instances {
+			for i, origin := range instances {
+				err := findVal(inst, fmt.Sprint("key", i), origin.Name)
+				if err != nil {
+					t.Fatalf("%v did not get the write from %v", inst.Name, origin.Name)
+				}
+			}
+		}
+	})
+
+	t.Run("detects diverging writes", func(t *testing.T) {
+		err := c.Disconnect(ctx, instances[0], instances[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = control.Put(ctx, instances[0], "split", "left")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = control.Put(ctx, instances[1], "split", "right")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(1 * time.Second)
+
+		conflicts, err := c.DetectConflicts(ctx, instances)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(conflicts.Divergences) == 0 && len(conflicts.Failed) == 0 {
+			t.Fatal("no conflicts after diverging writes")
+		}
+	})
+}
+
+func TestCascade(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{
+		"db1", "db2", "db3",
+	}
+
+	instances := make([]control.Instance, len(names))
+
+	for i, name := range names {
+		inst, err := c.AddInstance(ctx, name, os.Getenv("POSTGRES_IMAGE"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		instances[i] = inst
+	}
+
+	head, middle, tail := instances[0], instances[1], instances[2]
+
+	err = c.Connect(ctx, head, middle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Connect(ctx, middle, tail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SetupPrimary(ctx, head, control.DefaultSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("standby needs a publishing upstream", func(t *testing.T) {
+		err := c.SetupStandby(ctx, tail, middle)
+		if err == nil {
+			t.Fatal("subscribed to an instance without a publication")
+		}
+	})
+
+	err = c.SetupStandby(ctx, middle, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Republish(ctx, middle, control.DefaultSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SetupStandby(ctx, tail, middle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("changes flow down the chain", func(t *testing.T) {
+		err := control.Put(ctx, head, "test", "val")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(2 * time.Second)
+
+		err = findVal(tail, "test", "val")
+		if err != nil {
+			t.Fatal("failed to find data at the end of the chain")
+		}
+	})
+
+	t.Run("reads the chain", func(t *testing.T) {
+		topo, err := c.GetTopology(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(topo.Links) != 2 {
+			t.Fatalf("expected 2 links, got %v", len(topo.Links))
+		}
+	})
+
+	t.Run("refuses loops", func(t *testing.T) {
+		err := c.CheckLoop(ctx, head, tail)
+		if err == nil {
+			t.Fatal("allowed subscribing the head to the tail")
+		}
+	})
+}
+
+func TestLoopThroughLocalOnlyLink(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{
+		"db1", "db2", "db3",
+	}
+
+	instances := make([]control.Instance, len(names))
+
+	for i, name := range names {
+		inst, err := c.AddInstance(ctx, name, os.Getenv("POSTGRES_IMAGE"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		instances[i] = inst
+	}
+
+	head, middle, tail := instances[0], instances[1], instances[2]
+
+	err = c.Connect(ctx, head, middle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Connect(ctx, middle, tail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SetupPrimary(ctx, head, control.DefaultSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the middle only takes the head's own changes, but those are what would come back to it
+	err = c.SetupStandby(ctx, middle, head, control.OnlyLocalChanges())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Republish(ctx, middle, control.DefaultSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SetupStandby(ctx, tail, middle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.CheckLoop(ctx, head, tail)
+	if !errors.Is(err, control.ErrConflict) {
+		t.Fatalf("expected a loop through the local only link, got %v", err)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("invalid schemas are rejected", func(t *testing.T) {
+		err := c.SetSchema(control.Schema{})
+		if err == nil {
+			t.Fatal("expected missing ddl to fail")
+		}
+
+		err = c.SetSchema(control.Schema{
+			DDL: control.DDL,
+			Publications: []control.Publication{
+				{Name: "pub"},
+				{Name: "pub"},
+			},
+		})
+		if err == nil {
+			t.Fatal("expected duplicate publications to fail")
+		}
+	})
+
+	err = c.SetSchema(control.Schema{
+		DDL:  control.DDL + " CREATE TABLE items ( id int PRIMARY KEY, owner text, secret text );",
+		Seed: "INSERT INTO kv VALUES ('shared_seed', 'a'), ('local_seed', 'b'); INSERT INTO items VALUES (1, 'me', 'hidden');",
+		Publications: []control.Publication{
+			{
+				Name:   "shared",
+				Tables: []control.PublicationTable{{Name: "kv", Where: "key LIKE 'shared%'"}},
+			},
+			{
+				Name:   "items",
+				Tables: []control.PublicationTable{{Name: "public.items", Columns: []string{"id", "owner"}}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.SetSchema(control.DefaultSchema)
+
+	active, err := c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passive, err := c.AddInstance(ctx, "db2", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Connect(ctx, active, passive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SetupPrimary(ctx, active, c.Schema())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("standby needs a published publication", func(t *testing.T) {
+		err := c.SetupStandby(ctx, passive, active)
+		if err == nil {
+			t.Fatal("expected the default publication to be missing")
+		}
+	})
+
+	err = c.SetupStandby(ctx, passive, active, control.WithPublications(c.Schema().PublicationNames()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Second * 2)
+
+	t.Run("seed is copied through the row filter", func(t *testing.T) {
+		err := findVal(passive, "shared_seed", "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = findVal(passive, "local_seed", "b")
+		if err == nil {
+			t.Fatal("expected filtered row to stay on the primary")
+		}
+	})
+
+	t.Run("new rows follow the row filter", func(t *testing.T) {
+		err := control.Put(ctx, active, "shared_new", "c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = control.Put(ctx, active, "local_new", "d")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(time.Second * 2)
+
+		err = findVal(passive, "shared_new", "c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = findVal(passive, "local_new", "d")
+		if err == nil {
+			t.Fatal("expected filtered row to stay on the primary")
+		}
+	})
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inst, err := c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("rows come back as text", func(t *testing.T) {
+		res, err := control.Query(ctx, inst, "SELECT 1 AS one, NULL AS nothing, 'x' AS letter", control.QueryOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(res.Columns) != 3 || res.Columns[0] != "one" {
+			t.Fatalf("unexpected columns %v", res.Columns)
+		}
+		if len(res.Rows) != 1 {
+			t.Fatalf("expected one row, got %v", len(res.Rows))
+		}
+		row := res.Rows[0]
+		if *row[0] != "1" || row[1] != nil || *row[2] != "x" {
+			t.Fatal("unexpected row values")
+		}
+	})
+
+	t.Run("writes are committed", func(t *testing.T) {
+		res, err := control.Query(ctx, inst, "INSERT INTO kv VALUES ('key', 'value')", control.QueryOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Command != "INSERT 0 1" {
+			t.Fatalf("unexpected command tag %v", res.Command)
+		}
+
+		err = findVal(inst, "key", "value")
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("read only refuses writes", func(t *testing.T) {
+		_, err := control.Query(ctx, inst, "INSERT INTO kv VALUES ('other', 'value')", control.QueryOptions{
+			ReadOnly: true,
+		})
+		if err == nil {
+			t.Fatal("expected write to fail")
+		}
+	})
+
+	t.Run("slow statements time out", func(t *testing.T) {
+		_, err := control.Query(ctx, inst, "SELECT pg_sleep(5)", control.QueryOptions{
+			Timeout: 500 * time.Millisecond,
+		})
+		if err == nil {
+			t.Fatal("expected statement timeout")
+		}
+	})
+
+	t.Run("read only can't be committed away", func(t *testing.T) {
+		_, err := control.Query(ctx, inst, "COMMIT; INSERT INTO kv VALUES ('escaped', 'value')", control.QueryOptions{
+			ReadOnly: true,
+		})
+		if !errors.Is(err, control.ErrInvalid) {
+			t.Fatalf("expected invalid, got %v", err)
+		}
+
+		res, err := control.Query(ctx, inst, "SELECT value FROM kv WHERE key = 'escaped'", control.QueryOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Rows) != 0 {
+			t.Fatal("insert ran outside the read only transaction")
+		}
+	})
+
+	t.Run("timeout can't be turned off", func(t *testing.T) {
+		_, err := control.Query(ctx, inst, "SET LOCAL statement_timeout = 0; SELECT pg_sleep(5)", control.QueryOptions{
+			Timeout: 500 * time.Millisecond,
+		})
+		if !errors.Is(err, control.ErrInvalid) {
+			t.Fatalf("expected invalid, got %v", err)
+		}
+	})
+}
+
+func TestTerminal(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inst, err := c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	term, err := c.OpenTerminal(ctx, inst, 80, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer term.Close()
+
+	err = term.Resize(ctx, 120, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = term.Write([]byte("SELECT 40 + 2 AS answer;\n\\q\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// psql quitting ends the output
+	out, err := io.ReadAll(term)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(out), "42") {
+		t.Fatalf("expected the answer in the output, got %q", out)
+	}
+}
+
+func TestLogs(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inst, err := c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("past logs", func(t *testing.T) {
+		logs, err := c.StreamLogs(ctx, inst, control.LogOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer logs.Close()
+
+		out, err := io.ReadAll(logs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(string(out), "ready to accept connections") {
+			t.Fatal("expected startup message in the logs")
+		}
+	})
+
+	t.Run("follow new lines", func(t *testing.T) {
+		followCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		logs, err := c.StreamLogs(followCtx, inst, control.LogOptions{
+			Follow: true,
+			Tail:   "0",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer logs.Close()
+
+		// failed statements get logged by postgres
+		_, err = control.Query(ctx, inst, "SELECT missing_column_for_logs", control.QueryOptions{})
+		if err == nil {
+			t.Fatal("expected query to fail")
+		}
+
+		scanner := bufio.NewScanner(logs)
+		for scanner.Scan() {
+			if strings.Contains(scanner.Text(), "missing_column_for_logs") {
+				return
+			}
+		}
+		t.Fatal("expected the failed statement in the followed logs")
+	})
+}
+
+func TestEvents(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, cancel := c.SubscribeEvents(0)
+	defer cancel()
+
+	active, err := c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passive, err := c.AddInstance(ctx, "db2", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Connect(ctx, active, passive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SetupPrimary(ctx, active, control.DefaultSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SetupStandby(ctx, passive, active)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		control.EVENT_INSTANCE_ADDED,
+		control.EVENT_INSTANCE_ADDED,
+		control.EVENT_LINK_CONNECTED,
+		control.EVENT_ROLE_PRIMARY,
+		control.EVENT_ROLE_STANDBY,
+	}
+
+	var first uint64
+	for i, typ := range expected {
+		e := <-events
+		if e.Type != typ {
+			t.Fatalf("expected %v, got %v", typ, e.Type)
+		}
+		if i == 0 {
+			first = e.ID
+		}
+	}
+
+	t.Run("late subscribers catch up", func(t *testing.T) {
+		events, cancel := c.SubscribeEvents(first)
+		defer cancel()
+
+		e := <-events
+		if e.ID != first+1 || e.Type != control.EVENT_INSTANCE_ADDED {
+			t.Fatalf("expected the event after %v, got %v", first, e.ID)
+		}
+	})
+}
+
+func TestTraffic(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	active, err := c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passive, err := c.AddInstance(ctx, "db2", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Connect(ctx, active, passive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SetupPrimary(ctx, active, control.DefaultSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SetupStandby(ctx, passive, active)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = control.Put(ctx, active, "key", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Second * 2)
+
+	findLink := func() control.LinkTraffic {
+		links, err := c.GetTraffic(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, link := range links {
+			if link.Instance == active.Name && link.Peer == passive.Name {
+				return link
+			}
+		}
+		t.Fatal("cannot find the link")
+		return control.LinkTraffic{}
+	}
+
+	t.Run("replication shows up as traffic", func(t *testing.T) {
+		link := findLink()
+		if !link.Connected {
+			t.Fatal("expected link to be connected")
+		}
+		if link.Sent.Bytes == 0 || link.Received.Bytes == 0 {
+			t.Fatal("expected traffic both ways")
+		}
+		if link.Incoming == 0 {
+			t.Fatal("expected the standby to be connected to the primary")
+		}
+		if link.LastActivity.IsZero() {
+			t.Fatal("expected activity to be tracked")
+		}
+	})
+
+	t.Run("disconnected links keep their last activity", func(t *testing.T) {
+		err := c.Disconnect(ctx, active, passive)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		link := findLink()
+		if link.Connected {
+			t.Fatal("expected link to be disconnected")
+		}
+		if link.Sent.Bytes != 0 {
+			t.Fatal("expected no counters without a link")
+		}
+		if link.LastActivity.IsZero() {
+			t.Fatal("expected last activity to be kept")
+		}
+	})
+}
+
+func TestCapture(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	active, err := c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passive, err := c.AddInstance(ctx, "db2", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Connect(ctx, active, passive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitCapture := func(id string) control.Capture {
+		for {
+			capture, err := c.GetCapture(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if capture.State != control.CAPTURE_RUNNING {
+				return capture
+			}
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+
+	t.Run("replication gets captured", func(t *testing.T) {
+		capture, err := c.StartCapture(ctx, active, os.Getenv("CAPTURE_IMAGE"), 5*time.Second, "tcp port 5432")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = c.SetupPrimary(ctx, active, control.DefaultSchema)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = c.SetupStandby(ctx, passive, active)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		capture = waitCapture(capture.ID)
+		if capture.State != control.CAPTURE_DONE {
+			t.Fatalf("capture failed: %v", capture.Error)
+		}
+
+		f, err := c.OpenCapture(capture.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		// pcap files start with a magic number, followed by the packets
+		header := make([]byte, 4)
+		_, err = io.ReadFull(f, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(header) != "\xd4\xc3\xb2\xa1" && string(header) != "\xa1\xb2\xc3\xd4" {
+			t.Fatalf("not a pcap file: %x", header)
+		}
+		if capture.Size <= 24 {
+			t.Fatal("expected packets in the capture")
+		}
+
+		err = c.DeleteCapture(capture.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("invalid filters fail the capture", func(t *testing.T) {
+		capture, err := c.StartCapture(ctx, active, os.Getenv("CAPTURE_IMAGE"), 5*time.Second, "not a filter")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		capture = waitCapture(capture.ID)
+		if capture.State != control.CAPTURE_FAILED {
+			t.Fatal("expected capture to fail")
+		}
+	})
+}
+
+func TestListDuringAdd(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lists the way the traffic tracker does, while instances are half made or half removed
+	listErrs := make(chan error, 1)
+	go func() {
+		for ctx.Err() == nil {
+			insts, err := c.ListInstances(ctx)
+			if err == nil {
+				for _, inst := range insts {
+					if inst.NetworkID == "" {
+						err = fmt.Errorf("listed %v without its network", inst.Name)
+					}
+				}
+			}
+			if err != nil && ctx.Err() == nil {
+				listErrs <- err
+				return
+			}
+		}
+		close(listErrs)
+	}()
+
+	for i := range 3 {
+		inst, err := c.AddInstance(ctx, fmt.Sprintf("db%v", i), os.Getenv("POSTGRES_IMAGE"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = c.KillInstance(ctx, inst, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cancel()
+	err = <-listErrs
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inst1, err := c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inst2, err := c.AddInstance(ctx, "db2", os.Getenv("POSTGRES_IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("missing instance", func(t *testing.T) {
+		_, err := c.GetInstance(ctx, "netpart-missing")
+		if !errors.Is(err, control.ErrNotFound) {
+			t.Fatalf("expected not found, got %v", err)
+		}
+	})
+
+	t.Run("duplicate instance", func(t *testing.T) {
+		_, err := c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"))
+		if !errors.Is(err, control.ErrAlreadyExists) {
+			t.Fatalf("expected already exists, got %v", err)
+		}
+	})
+
+	t.Run("invalid sql", func(t *testing.T) {
+		_, err := control.Query(ctx, inst1, "SELEC 1", control.QueryOptions{})
+		if control.KindOf(err) != control.ErrInvalid {
+			t.Fatalf("expected invalid, got %v", err)
+		}
+	})
+
+	t.Run("write in read only query", func(t *testing.T) {
+		_, err := control.Query(ctx, inst1, "INSERT INTO kv VALUES ('key', 'value')", control.QueryOptions{
+			ReadOnly: true,
+		})
+		if control.KindOf(err) != control.ErrInvalid {
+			t.Fatalf("expected invalid, got %v", err)
+		}
+	})
+
+	t.Run("postgres failing isn't the caller's fault", func(t *testing.T) {
+		// raises admin_shutdown for the query's own connection
+		_, err := control.Query(ctx, inst1, "SELECT pg_terminate_backend(pg_backend_pid())", control.QueryOptions{})
+		if err == nil {
+			t.Fatal("expected the connection to be terminated")
+		}
+		if control.KindOf(err) == control.ErrInvalid {
+			t.Fatalf("expected no kind, got invalid for %v", err)
+		}
+	})
+
+	t.Run("invalid parameter value", func(t *testing.T) {
+		err := control.SetParameters(ctx, inst1, map[string]string{
+			"wal_sender_timeout": "abc",
+		})
+		if control.KindOf(err) != control.ErrInvalid {
+			t.Fatalf("expected invalid, got %v", err)
+		}
+	})
+
+	t.Run("statement timeout", func(t *testing.T) {
+		_, err := control.Query(ctx, inst1, "SELECT pg_sleep(5)", control.QueryOptions{
+			Timeout: 500 * time.Millisecond,
+		})
+		if control.KindOf(err) != control.ErrTimeout {
+			t.Fatalf("expected timeout, got %v", err)
+		}
+	})
+
+	t.Run("connecting twice", func(t *testing.T) {
+		err := c.Connect(ctx, inst1, inst2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = c.Connect(ctx, inst1, inst2)
+		if control.KindOf(err) != control.ErrConflict {
+			t.Fatalf("expected conflict, got %v", err)
+		}
+	})
+
+	t.Run("partitioned multi-master", func(t *testing.T) {
+		err := c.Disconnect(ctx, inst1, inst2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = c.SetupMultiMaster(ctx, []control.Instance{inst1, inst2})
+		if !errors.Is(err, control.ErrUnreachable) {
+			t.Fatalf("expected unreachable, got %v", err)
+		}
+	})
+}
+
+func findParam(inst control.Instance, name string, setting string) error {
+	ctx := context.Background()
+	params, err := control.GetParameters(ctx, inst)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range params {
+		if p.Name == name {
+			if p.Setting != setting {
+				return fmt.Errorf("expected %v to be %v, got %v", name, setting, p.Setting)
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("failed to find parameter %v", name)
+}
+
 func findVal(inst control.Instance, key string, value string) error {
 	ctx := context.Background()
 	val, err := control.Get(ctx, inst)
@@ -339,3 +1973,62 @@ func findVal(inst control.Instance, key string, value string) error {
 
 	return nil
 }
+
+func TestInstanceNames(t *testing.T) {
+	ctx := context.Background()
+
+	err := c.Cleanup(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("rejects invalid names", func(t *testing.T) {
+		for _, name := range []string{"", "DB1", "db_1", "db--1", "-db1", "db1-", "db 1", "db1;", "snapshot-db1", strings.Repeat("a", control.MAX_NAME_LENGTH+1)} {
+			_, err := c.AddInstance(ctx, name, os.Getenv("POSTGRES_IMAGE"))
+			if !errors.Is(err, control.ErrInvalid) {
+				t.Fatalf("expected invalid for %q, got %v", name, err)
+			}
+		}
+
+		insts, err := c.ListInstances(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(insts) != 0 {
+			t.Fatalf("expected no instances, got %v", insts)
+		}
+	})
+
+	t.Run("prefix is optional", func(t *testing.T) {
+		inst, err := c.AddInstance(ctx, control.PREFIX+"db1", os.Getenv("POSTGRES_IMAGE"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inst.Name != control.PREFIX+"db1" {
+			t.Fatalf("expected %v, got %v", control.PREFIX+"db1", inst.Name)
+		}
+
+		_, err = c.GetInstance(ctx, "db1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = c.AddInstance(ctx, "db1", os.Getenv("POSTGRES_IMAGE"))
+		if !errors.Is(err, control.ErrAlreadyExists) {
+			t.Fatalf("expected already exists, got %v", err)
+		}
+	})
+
+	t.Run("dashes and underscores don't collide", func(t *testing.T) {
+		// subscriptions turn - into _, so a standby of both would have two subscriptions named sub_db1_b_c
+		_, err := c.AddInstance(ctx, "b-c", os.Getenv("POSTGRES_IMAGE"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = c.AddInstance(ctx, "b_c", os.Getenv("POSTGRES_IMAGE"))
+		if !errors.Is(err, control.ErrInvalid) {
+			t.Fatalf("expected invalid, got %v", err)
+		}
+	})
+}
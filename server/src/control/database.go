@@ -2,10 +2,13 @@ package control
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
+	"github.com/docker/docker/api/types/container"
 	"github.com/jackc/pgx/v5"
 )
 
@@ -14,22 +17,80 @@ const POSTGRES_PASSWORD = "postgres"
 const POSTGRES_DB = "main"
 
 func getConn(ctx context.Context, port string) (*pgx.Conn, error) {
+	// stopped containers don't have their port published
+	if port == "" {
+		return nil, errorf(ErrConflict, "instance is not running")
+	}
+
 	for {
 		connString := "postgresql://" + POSTGRES_USER + ":" + POSTGRES_PASSWORD + "@dind:" + port + "/" + POSTGRES_DB
 		conn, err := pgx.Connect(ctx, connString)
-		if err != nil {
-			fmt.Println("pinging database failed, retrying...")
-			time.Sleep(500 * time.Millisecond)
-		} else {
-			fmt.Println("database connected!")
+		if err == nil {
+			slog.DebugContext(ctx, "database connected", "port", port)
 			return conn, nil
 		}
+
+		slog.DebugContext(ctx, "pinging database failed, retrying", "port", port, "err", err)
+		select {
+		case <-ctx.Done():
+			kind := ErrUnreachable
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				kind = ErrTimeout
+			}
+			return nil, errorf(kind, "cannot connect to database: %w", err)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// Waits until postgres in a new container accepts connections.
+// Postgres exits on startup when a parameter is unknown or has a bad value, and getConn alone would keep retrying until ctx is done.
+func (c *ControlPlane) waitStarted(ctx context.Context, inst Instance) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	exited, waitErrs := c.cli.ContainerWait(ctx, inst.ContainerID, container.WaitConditionNotRunning)
+
+	ready := make(chan error, 1)
+	go func() {
+		conn, err := getConn(ctx, inst.Port)
+		if err == nil {
+			conn.Close(context.Background())
+		}
+		ready <- err
+	}()
+
+	select {
+	case err := <-ready:
+		return err
+	case res := <-exited:
+		msg := "see the instance's logs"
+		logs, err := c.readLogs(ctx, inst, "50")
+		if err == nil {
+			if fatal := startupError(logs); fatal != "" {
+				msg = fatal
+			}
+		}
+		return errorf(ErrInvalid, "postgres exited on startup with code %v: %v", res.StatusCode, msg)
+	case err := <-waitErrs:
+		return err
 	}
 }
 
+// the first FATAL message postgres logged, which tells why it didn't start.
+func startupError(logs string) string {
+	for _, line := range strings.Split(logs, "\n") {
+		m := logLine.FindStringSubmatch(line)
+		if m != nil && m[3] == "FATAL" {
+			return m[4]
+		}
+	}
+	return ""
+}
+
 const DDL = "CREATE TABLE IF NOT EXISTS kv ( key text PRIMARY KEY, value text );"
 
-func SetupDB(ctx context.Context, inst Instance) error {
+func SetupDB(ctx context.Context, inst Instance, schema Schema) error {
 	conn, err := getConn(ctx, inst.Port)
 	if err != nil {
 		return err
@@ -37,16 +98,43 @@ func SetupDB(ctx context.Context, inst Instance) error {
 
 	defer conn.Close(ctx)
 
-	_, err = conn.Exec(ctx, DDL)
+	_, err = conn.Exec(ctx, schema.DDL)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-const PUB = "CREATE PUBLICATION pub FOR TABLE kv;"
+// the publication of the default schema
+const PUB_NAME = "pub"
 
-func SetupPrimary(ctx context.Context, inst Instance) error {
+// Creates the schema's publications and loads the seed data, which standbys then copy over.
+func (c *ControlPlane) SetupPrimary(ctx context.Context, inst Instance, schema Schema) error {
+	err := setupPublications(ctx, inst, schema)
+	if err != nil {
+		return err
+	}
+
+	if schema.Seed != "" {
+		conn, err := getConn(ctx, inst.Port)
+		if err != nil {
+			return err
+		}
+
+		defer conn.Close(ctx)
+
+		_, err = conn.Exec(ctx, schema.Seed)
+		if err != nil {
+			return fmt.Errorf("cannot seed %v: %w", inst.Name, err)
+		}
+	}
+
+	slog.InfoContext(ctx, "primary setup", "op", "setup_primary", "instance", inst)
+	c.publish(EVENT_ROLE_PRIMARY, fmt.Sprintf("%v is now a primary", inst.Name), inst)
+	return nil
+}
+
+func setupPublications(ctx context.Context, inst Instance, schema Schema) error {
 	conn, err := getConn(ctx, inst.Port)
 	if err != nil {
 		return err
@@ -54,16 +142,115 @@ func SetupPrimary(ctx context.Context, inst Instance) error {
 
 	defer conn.Close(ctx)
 
-	_, err = conn.Exec(ctx, PUB)
+	tx, err := conn.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
 
-	fmt.Printf("active setup at %v\n", inst.Name)
-	return nil
+	for _, pub := range schema.Publications {
+		_, err = tx.Exec(ctx, pub.createStatement())
+		if err != nil {
+			return fmt.Errorf("cannot create publication %v: %w", pub.Name, err)
+		}
+	}
+
+	return tx.Commit(ctx)
 }
 
-func SetupStandby(ctx context.Context, inst Instance, active Instance) error {
+func hasPublication(ctx context.Context, inst Instance, publication string) (bool, error) {
+	conn, err := getConn(ctx, inst.Port)
+	if err != nil {
+		return false, err
+	}
+
+	defer conn.Close(ctx)
+
+	var published bool
+	err = conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_publication WHERE pubname = $1)", publication).Scan(&published)
+	if err != nil {
+		return false, err
+	}
+
+	return published, nil
+}
+
+// whether the instance publishes anything at all
+func isPrimary(ctx context.Context, inst Instance) (bool, error) {
+	conn, err := getConn(ctx, inst.Port)
+	if err != nil {
+		return false, err
+	}
+
+	defer conn.Close(ctx)
+
+	var published bool
+	err = conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_publication)").Scan(&published)
+	if err != nil {
+		return false, err
+	}
+
+	return published, nil
+}
+
+// StandbyOption customizes the subscription made by SetupStandby.
+type StandbyOption func(*standbyConfig)
+
+type standbyConfig struct {
+	copyData     bool
+	origin       string
+	publications []string
+}
+
+// WithoutCopy skips the initial table copy, for standbys that already have the primary's data.
+func WithoutCopy() StandbyOption {
+	return func(cfg *standbyConfig) {
+		cfg.copyData = false
+	}
+}
+
+// OnlyLocalChanges subscribes only to changes made on the primary itself, skipping what it replicated from others.
+// This is what keeps changes from looping around when instances replicate from each other.
+func OnlyLocalChanges() StandbyOption {
+	return func(cfg *standbyConfig) {
+		cfg.origin = "none"
+	}
+}
+
+// WithPublications picks which of the upstream's publications to subscribe to, instead of the default schema's.
+func WithPublications(publications ...string) StandbyOption {
+	return func(cfg *standbyConfig) {
+		cfg.publications = publications
+	}
+}
+
+// The upstream doesn't have to be a primary, a standby that republishes what it receives works too.
+func (c *ControlPlane) SetupStandby(ctx context.Context, inst Instance, active Instance, opts ...StandbyOption) error {
+	cfg := standbyConfig{
+		copyData:     true,
+		origin:       "any",
+		publications: []string{PUB_NAME},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if len(cfg.publications) == 0 {
+		return errorf(ErrInvalid, "standby needs at least one publication")
+	}
+
+	pubs := make([]string, len(cfg.publications))
+	for i, pub := range cfg.publications {
+		published, err := hasPublication(ctx, active, pub)
+		if err != nil {
+			return err
+		}
+		if !published {
+			return errorf(ErrConflict, "%v does not publish %v", active.Name, pub)
+		}
+		pubs[i] = pgx.Identifier{pub}.Sanitize()
+	}
+
 	conn, err := getConn(ctx, inst.Port)
 	if err != nil {
 		return err
@@ -71,26 +258,26 @@ func SetupStandby(ctx context.Context, inst Instance, active Instance) error {
 
 	defer conn.Close(ctx)
 
-	// replication slot name can only be numbers, alpha, and underscores.
-	sanitized_subscription := strings.ReplaceAll("sub_"+inst.Name, "-", "_")
+	sanitized_subscription := subscriptionName(inst, active)
 
 	// TODO:
 	// this is vulnerable to sql injection actually
 	// but you can't turn create subscription into a prepared statement
 	sub := fmt.Sprintf(
-		"CREATE SUBSCRIPTION \"%v\" CONNECTION 'host=%v dbname=%v user=%v password=%v' PUBLICATION pub WITH (disable_on_error = true);",
-		sanitized_subscription, active.Name, POSTGRES_DB, POSTGRES_USER, POSTGRES_PASSWORD)
+		"CREATE SUBSCRIPTION \"%v\" CONNECTION '%v' PUBLICATION %v WITH (disable_on_error = true, copy_data = %v, origin = %v);",
+		sanitized_subscription, connInfo(active), strings.Join(pubs, ", "), cfg.copyData, cfg.origin)
 
 	_, err = conn.Exec(ctx, sub)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("standby setup at %v\n", inst.Name)
+	slog.InfoContext(ctx, "standby setup", "op", "setup_standby", "instance", inst, "upstream", active)
+	c.publish(EVENT_ROLE_STANDBY, fmt.Sprintf("%v is now a standby of %v", inst.Name, active.Name), inst, active)
 	return nil
 }
 
-func RestartStandby(ctx context.Context, inst Instance, active Instance) error {
+func (c *ControlPlane) RestartStandby(ctx context.Context, inst Instance, active Instance) error {
 	conn, err := getConn(ctx, inst.Port)
 	if err != nil {
 		return err
@@ -98,8 +285,7 @@ func RestartStandby(ctx context.Context, inst Instance, active Instance) error {
 
 	defer conn.Close(ctx)
 
-	// replication slot name can only be numbers, alpha, and underscores.
-	sanitized_subscription := strings.ReplaceAll("sub_"+inst.Name, "-", "_")
+	sanitized_subscription := subscriptionName(inst, active)
 
 	// TODO:
 	// this is vulnerable to sql injection actually
@@ -111,11 +297,15 @@ func RestartStandby(ctx context.Context, inst Instance, active Instance) error {
 		return err
 	}
 
-	fmt.Printf("standby restarted at %v\n", inst.Name)
+	slog.InfoContext(ctx, "standby restarted", "op", "restart_standby", "instance", inst, "upstream", active)
+	c.publish(EVENT_SUBSCRIPTION_ENABLED, fmt.Sprintf("enabled %v", sanitized_subscription), inst, active)
 	return nil
 }
 
-func Put(ctx context.Context, inst Instance, key string, value string) error {
+func Put(ctx context.Context, inst Instance, key string, value string) (err error) {
+	start := time.Now()
+	defer func() { observeKV("put", inst, start, err) }()
+
 	conn, err := getConn(ctx, inst.Port)
 	if err != nil {
 		return err
@@ -136,7 +326,10 @@ type KV struct {
 	Value string
 }
 
-func Get(ctx context.Context, inst Instance) ([]KV, error) {
+func Get(ctx context.Context, inst Instance) (_ []KV, err error) {
+	start := time.Now()
+	defer func() { observeKV("get", inst, start, err) }()
+
 	conn, err := getConn(ctx, inst.Port)
 	if err != nil {
 		return nil, err
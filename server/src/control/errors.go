@@ -0,0 +1,110 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// The kinds of failure callers may want to tell apart, check them with errors.Is.
+// Anything else is a failure of the control plane itself.
+var (
+	ErrInvalid       = errors.New("invalid")
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+	ErrConflict      = errors.New("conflict") // the target isn't in a state that allows it
+	ErrUnreachable   = errors.New("unreachable")
+	ErrTimeout       = errors.New("timeout")
+)
+
+// An error of a given kind. The message is the one of err, the kind only shows up in errors.Is.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+func errorf(kind error, format string, args ...any) error {
+	return &kindError{kind: kind, err: fmt.Errorf(format, args...)}
+}
+
+// the SQLSTATE of a postgres error, empty for any other error.
+func pgCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// KindOf returns which of the kinds above err is, or nil when it's none of them.
+// Errors made here carry their kind already, the docker and postgres errors that are passed through are recognized by their codes.
+func KindOf(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	for _, kind := range []error{ErrInvalid, ErrNotFound, ErrAlreadyExists, ErrConflict, ErrUnreachable, ErrTimeout} {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == "57014": // query_canceled, which is what statement_timeout raises
+			return ErrTimeout
+		case strings.HasPrefix(pgErr.Code, "08"): // connection exceptions, e.g. a subscription that can't reach its publisher
+			return ErrUnreachable
+		case pgErr.Code == "42710", pgErr.Code == "42P07", pgErr.Code == "42P04": // duplicate object, table or database
+			return ErrAlreadyExists
+		case pgErr.Code == "42704", pgErr.Code == "42P01": // undefined object or table
+			return ErrNotFound
+		case pgErr.Code == "55006", pgErr.Code == "40001", pgErr.Code == "40P01", pgErr.Code == "23505":
+			// object in use, serialization failure, deadlock and unique violation
+			return ErrConflict
+		}
+		// syntax and data errors are only the caller's fault when the caller wrote the sql, see queryError
+		return nil
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errdefs.IsDeadline(err):
+		return ErrTimeout
+	case errdefs.IsNotFound(err):
+		return ErrNotFound
+	case errdefs.IsConflict(err), errdefs.IsForbidden(err):
+		// docker answers forbidden for connecting networks that already are, and disconnecting ones that aren't
+		return ErrConflict
+	case errdefs.IsInvalidParameter(err):
+		return ErrInvalid
+	case errdefs.IsUnavailable(err), client.IsErrConnectionFailed(err):
+		return ErrUnreachable
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTimeout
+	}
+
+	var connErr *pgconn.ConnectError
+	if errors.As(err, &connErr) {
+		return ErrUnreachable
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package control
+
+import (
+	"sync"
+	"time"
+)
+
+// kinds of events published by the control plane
+const (
+	EVENT_INSTANCE_ADDED     = "instance.added"
+	EVENT_INSTANCE_KILLED    = "instance.killed"
+	EVENT_INSTANCE_PAUSED    = "instance.paused"
+	EVENT_INSTANCE_UNPAUSED  = "instance.unpaused"
+	EVENT_INSTANCE_SIGNALED  = "instance.signaled"
+	EVENT_INSTANCE_RESTARTED = "instance.restarted"
+	EVENT_INSTANCE_RESTORED  = "instance.restored"
+
+	EVENT_LINK_CONNECTED    = "link.connected"
+	EVENT_LINK_DISCONNECTED = "link.disconnected"
+
+	EVENT_ROLE_PRIMARY     = "role.primary"
+	EVENT_ROLE_STANDBY     = "role.standby"
+	EVENT_ROLE_REPUBLISHED = "role.republished"
+	EVENT_ROLE_REPOINTED   = "role.repointed"
+	EVENT_ROLE_DROPPED     = "role.dropped"
+
+	EVENT_SUBSCRIPTION_ENABLED  = "subscription.enabled"
+	EVENT_SUBSCRIPTION_DISABLED = "subscription.disabled"
+)
+
+// how many past events are kept for subscribers that reconnect
+const EVENT_HISTORY = 256
+
+// how many events a subscriber can fall behind before it starts missing them
+const EVENT_BUFFER = 64
+
+type Event struct {
+	ID        uint64 // increases by one with every event
+	Time      time.Time
+	Type      string
+	Instances []string // the instances involved, the acted upon one first
+	Message   string
+}
+
+type eventBus struct {
+	mu      sync.Mutex
+	lastID  uint64
+	history []Event
+	subs    map[chan Event]struct{}
+}
+
+// Subscribes to every event published after the event with the given id, 0 for only new ones.
+// Events that already fell out of the history are skipped.
+// The returned function has to be called once the subscriber is done.
+func (c *ControlPlane) SubscribeEvents(after uint64) (<-chan Event, func()) {
+	bus := &c.events
+
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	ch := make(chan Event, EVENT_BUFFER+EVENT_HISTORY)
+	if after != 0 {
+		for _, e := range bus.history {
+			if e.ID > after {
+				ch <- e
+			}
+		}
+	}
+
+	if bus.subs == nil {
+		bus.subs = make(map[chan Event]struct{})
+	}
+	bus.subs[ch] = struct{}{}
+
+	cancel := func() {
+		bus.mu.Lock()
+		defer bus.mu.Unlock()
+
+		if _, ok := bus.subs[ch]; ok {
+			delete(bus.subs, ch)
+			close(ch)
+		}
+	}
+
+	return ch, cancel
+}
+
+// Never blocks, subscribers that are too slow miss the event instead.
+func (c *ControlPlane) publish(typ string, message string, insts ...Instance) {
+	bus := &c.events
+
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	bus.lastID++
+	e := Event{
+		ID:        bus.lastID,
+		Time:      time.Now(),
+		Type:      typ,
+		Instances: make([]string, len(insts)),
+		Message:   message,
+	}
+	for i, inst := range insts {
+		e.Instances[i] = inst.Name
+	}
+
+	bus.history = append(bus.history, e)
+	if len(bus.history) > EVENT_HISTORY {
+		bus.history = bus.history[1:]
+	}
+
+	for ch := range bus.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Runs cmd inside the container, feeding it stdin if given and copying its stdout.
+// Fails if the command exits with a non-zero code, with whatever it printed to stderr.
+func (c *ControlPlane) exec(ctx context.Context, containerID string, cmd []string, stdin io.Reader, stdout io.Writer) error {
+	exec, err := c.cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          cmd,
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	hijack, err := c.cli.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return err
+	}
+	defer hijack.Close()
+
+	if stdin != nil {
+		go func() {
+			io.Copy(hijack.Conn, stdin)
+			hijack.CloseWrite()
+		}()
+	}
+
+	var stderr bytes.Buffer
+	_, err = stdcopy.StdCopy(stdout, &stderr, hijack.Reader)
+	if err != nil {
+		return err
+	}
+
+	// the stream can close slightly before docker records the exit code
+	for {
+		inspect, err := c.cli.ContainerExecInspect(ctx, exec.ID)
+		if err != nil {
+			return err
+		}
+
+		if inspect.Running {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		if inspect.ExitCode != 0 {
+			return fmt.Errorf("%v exited with %v: %v", cmd[0], inspect.ExitCode, strings.TrimSpace(stderr.String()))
+		}
+
+		return nil
+	}
+}
+
+// Streams the output of srcCmd in one container into dstCmd in another.
+func (c *ControlPlane) pipe(ctx context.Context, srcID string, srcCmd []string, dstID string, dstCmd []string) error {
+	pr, pw := io.Pipe()
+
+	srcErr := make(chan error, 1)
+	go func() {
+		err := c.exec(ctx, srcID, srcCmd, nil, pw)
+		pw.CloseWithError(err)
+		srcErr <- err
+	}()
+
+	err := c.exec(ctx, dstID, dstCmd, pr, io.Discard)
+	// unblocks the source if the destination quit early
+	pr.CloseWithError(fmt.Errorf("%v stopped reading", dstCmd[0]))
+
+	// a failed source still closes stdin, so the destination may think it succeeded
+	if err := <-srcErr; err != nil {
+		return err
+	}
+
+	return err
+}
@@ -0,0 +1,74 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// signals that postgres reacts to in an interesting way.
+// TERM, INT, and QUIT are smart, fast, and immediate shutdowns, KILL is a crash.
+var SIGNALS = []string{"SIGKILL", "SIGTERM", "SIGINT", "SIGQUIT"}
+
+// Freezes every process of the instance, connections stay open but nothing gets answered.
+func (c *ControlPlane) PauseInstance(ctx context.Context, inst Instance) error {
+	err := c.cli.ContainerPause(ctx, inst.ContainerID)
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "paused container", "op", "pause_instance", "instance", inst)
+	c.publish(EVENT_INSTANCE_PAUSED, fmt.Sprintf("paused %v", inst.Name), inst)
+	return nil
+}
+
+func (c *ControlPlane) UnpauseInstance(ctx context.Context, inst Instance) error {
+	err := c.cli.ContainerUnpause(ctx, inst.ContainerID)
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "unpaused container", "op", "unpause_instance", "instance", inst)
+	c.publish(EVENT_INSTANCE_UNPAUSED, fmt.Sprintf("unpaused %v", inst.Name), inst)
+	return nil
+}
+
+// Sends the signal to the postmaster, which runs as the container's main process.
+// Postgres exits afterwards, so the container is left stopped until RestartInstance.
+func (c *ControlPlane) SignalInstance(ctx context.Context, inst Instance, signal string) error {
+	if !slices.Contains(SIGNALS, signal) {
+		return errorf(ErrInvalid, "unsupported signal %v", signal)
+	}
+
+	err := c.cli.ContainerKill(ctx, inst.ContainerID, signal)
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "signaled container", "op", "signal_instance", "instance", inst, "signal", signal)
+	c.publish(EVENT_INSTANCE_SIGNALED, fmt.Sprintf("sent %v to %v", signal, inst.Name), inst)
+	return nil
+}
+
+// Restarts the container while keeping its name and networks.
+// Docker publishes the instance on a new port afterwards, so the returned instance should be used from then on.
+func (c *ControlPlane) RestartInstance(ctx context.Context, inst Instance) (Instance, error) {
+	if inst.State == "paused" {
+		err := c.UnpauseInstance(ctx, inst)
+		if err != nil {
+			return Instance{}, err
+		}
+	}
+
+	err := c.cli.ContainerRestart(ctx, inst.ContainerID, container.StopOptions{})
+	if err != nil {
+		return Instance{}, err
+	}
+
+	slog.InfoContext(ctx, "restarted container", "op", "restart_instance", "instance", inst)
+	c.publish(EVENT_INSTANCE_RESTARTED, fmt.Sprintf("restarted %v", inst.Name), inst)
+	return c.GetInstance(ctx, inst.Name)
+}
@@ -0,0 +1,141 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Reads the last lines postgres printed. Postgres logs to stderr in the official image.
+func (c *ControlPlane) readLogs(ctx context.Context, inst Instance, tail string) (string, error) {
+	logs, err := c.cli.ContainerLogs(ctx, inst.ContainerID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       tail,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer logs.Close()
+
+	var out bytes.Buffer
+	_, err = stdcopy.StdCopy(&out, &out, logs)
+	if err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
+
+type LogOptions struct {
+	Follow     bool   // keeps streaming new lines until ctx is done
+	Since      string // a timestamp or a duration like 10m, everything when empty
+	Tail       string // number of lines from the end, everything when empty
+	Timestamps bool   // prefixes lines with docker's timestamp
+}
+
+// Streams the container's logs with stdout and stderr merged.
+func (c *ControlPlane) StreamLogs(ctx context.Context, inst Instance, opts LogOptions) (io.ReadCloser, error) {
+	tail := opts.Tail
+	if tail == "" {
+		tail = "all"
+	}
+
+	logs, err := c.cli.ContainerLogs(ctx, inst.ContainerID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     opts.Follow,
+		Since:      opts.Since,
+		Tail:       tail,
+		Timestamps: opts.Timestamps,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pw, pw, logs)
+		logs.Close()
+		pw.CloseWithError(err)
+	}()
+
+	return pr, nil
+}
+
+// matches the default log_line_prefix of '%m [%p] '
+var logLine = regexp.MustCompile(`^(\S+ \S+ \S+) \[(\d+)\] ([A-Z]+):\s+(.*)$`)
+
+var workerStarted = regexp.MustCompile(`^logical replication (?:apply|table synchronization) worker for subscription "([^"]+)".* has started$`)
+
+var finishedAt = regexp.MustCompile(`finished at ([0-9A-F]+/[0-9A-F]+)`)
+
+// Finds the last error hit by each subscription's workers.
+// Workers are tied to their subscription by pid, since only their startup message names it.
+func parseSubscriptionErrors(logs string) map[string]SubscriptionError {
+	workers := make(map[string]string)
+	errs := make(map[string]SubscriptionError)
+
+	// error currently being read, detail and context lines follow it
+	var current *SubscriptionError
+	var currentSub string
+
+	flush := func() {
+		if current != nil {
+			errs[currentSub] = *current
+			current = nil
+		}
+	}
+
+	for _, line := range strings.Split(logs, "\n") {
+		m := logLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		time, pid, level, msg := m[1], m[2], m[3], m[4]
+
+		if started := workerStarted.FindStringSubmatch(msg); started != nil {
+			workers[pid] = started[1]
+			continue
+		}
+
+		sub, ok := workers[pid]
+		if !ok {
+			continue
+		}
+
+		switch level {
+		case "ERROR", "FATAL":
+			flush()
+			current = &SubscriptionError{
+				Time:     time,
+				Message:  msg,
+				Conflict: strings.HasPrefix(msg, "duplicate key value violates unique constraint"),
+			}
+			currentSub = sub
+		case "DETAIL":
+			if current != nil && currentSub == sub {
+				current.Detail = msg
+			}
+		case "CONTEXT":
+			if current != nil && currentSub == sub {
+				current.Context = msg
+				if lsn := finishedAt.FindStringSubmatch(msg); lsn != nil {
+					current.Finish_Lsn = lsn[1]
+				}
+			}
+		default:
+			if currentSub == sub {
+				flush()
+			}
+		}
+	}
+	flush()
+
+	return errs
+}
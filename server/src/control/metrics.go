@@ -0,0 +1,201 @@
+package control
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	kvDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "netpart_kv_duration_seconds",
+		Help:    "Time taken by puts and gets, including connecting to the instance.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"op", "instance", "result"})
+
+	dockerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "netpart_docker_request_duration_seconds",
+		Help:    "Time taken by calls to the docker API.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "endpoint"})
+
+	dockerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "netpart_docker_request_errors_total",
+		Help: "Calls to the docker API that failed or got an error status.",
+	}, []string{"method", "endpoint", "code"})
+)
+
+func observeKV(op string, inst Instance, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	kvDuration.WithLabelValues(op, inst.Name, result).Observe(time.Since(start).Seconds())
+}
+
+var apiVersion = regexp.MustCompile(`^v[0-9.]+$`)
+
+// path segments after these are ids, unless they are one of the fixed endpoints
+var idParents = map[string]bool{"containers": true, "networks": true, "volumes": true, "exec": true}
+var fixedEndpoints = map[string]bool{"json": true, "create": true, "prune": true}
+
+// Turns /v1.47/containers/abc/start into /containers/{id}/start, so ids don't blow up the label count.
+func dockerEndpoint(path string) string {
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segs) > 0 && apiVersion.MatchString(segs[0]) {
+		segs = segs[1:]
+	}
+
+	for i := 1; i < len(segs); i++ {
+		if idParents[segs[i-1]] && !fixedEndpoints[segs[i]] {
+			segs[i] = "{id}"
+		}
+	}
+
+	return "/" + strings.Join(segs, "/")
+}
+
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := dockerEndpoint(req.URL.Path)
+	start := time.Now()
+
+	res, err := t.next.RoundTrip(req)
+
+	// streams like logs and attaches are only timed until the headers arrive
+	dockerDuration.WithLabelValues(req.Method, endpoint).Observe(time.Since(start).Seconds())
+	if err != nil {
+		dockerErrors.WithLabelValues(req.Method, endpoint, "").Inc()
+	} else if res.StatusCode >= 400 {
+		dockerErrors.WithLabelValues(req.Method, endpoint, strconv.Itoa(res.StatusCode)).Inc()
+	}
+
+	return res, err
+}
+
+// Records the duration and errors of every docker API call.
+// Has to come after the options that set up the client's transport.
+func withDockerMetrics() client.Opt {
+	return func(cli *client.Client) error {
+		hc := cli.HTTPClient()
+		hc.Transport = instrumentedTransport{next: hc.Transport}
+		return client.WithHTTPClient(hc)(cli)
+	}
+}
+
+var (
+	instancesDesc = prometheus.NewDesc(
+		"netpart_instances",
+		"Instances managed by netpart, by container state.",
+		[]string{"state"}, nil,
+	)
+	linkDesc = prometheus.NewDesc(
+		"netpart_link_connected",
+		"Whether two instances share a network, 1 when connected.",
+		[]string{"instance", "peer"}, nil,
+	)
+	lagDesc = prometheus.NewDesc(
+		"netpart_subscription_lag_bytes",
+		"WAL the subscription has yet to confirm, measured on the publisher's slot.",
+		[]string{"publisher", "subscription"}, nil,
+	)
+)
+
+// how long a scrape may spend on docker and each instance
+const SCRAPE_TIMEOUT = 5 * time.Second
+
+// Reads the state of the instances whenever prometheus scrapes.
+type collector struct {
+	c *ControlPlane
+}
+
+func (c *ControlPlane) Collector() prometheus.Collector {
+	return collector{c: c}
+}
+
+func (col collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- instancesDesc
+	ch <- linkDesc
+	ch <- lagDesc
+}
+
+func (col collector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), SCRAPE_TIMEOUT)
+	defer cancel()
+
+	insts, err := col.c.ListInstances(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "cannot list instances for metrics", "err", err)
+		return
+	}
+
+	states := make(map[string]int)
+	for _, inst := range insts {
+		states[inst.State]++
+	}
+	for state, count := range states {
+		ch <- prometheus.MustNewConstMetric(instancesDesc, prometheus.GaugeValue, float64(count), state)
+	}
+
+	networks := make(map[string]map[string]bool)
+	for _, inst := range insts {
+		res, err := col.c.cli.ContainerInspect(ctx, inst.ContainerID)
+		if err != nil {
+			slog.WarnContext(ctx, "cannot inspect instance for metrics", "instance", inst, "err", err)
+			continue
+		}
+
+		networks[inst.Name] = make(map[string]bool)
+		for _, n := range res.NetworkSettings.Networks {
+			networks[inst.Name][n.NetworkID] = true
+		}
+	}
+
+	// same rule as GetConnection, the higher one joins the lower one's network
+	for i, lower := range insts {
+		for _, higher := range insts[i+1:] {
+			nets, ok := networks[higher.Name]
+			if !ok {
+				continue
+			}
+
+			connected := 0.0
+			if nets[lower.NetworkID] {
+				connected = 1
+			}
+			ch <- prometheus.MustNewConstMetric(linkDesc, prometheus.GaugeValue, connected, lower.Name, higher.Name)
+		}
+	}
+
+	for _, inst := range insts {
+		if inst.State != "running" {
+			continue
+		}
+
+		instCtx, cancel := context.WithTimeout(ctx, SCRAPE_TIMEOUT/time.Duration(len(insts)+1))
+		slots, err := ListSlots(instCtx, inst)
+		cancel()
+		if err != nil {
+			slog.WarnContext(ctx, "cannot list slots for metrics", "instance", inst, "err", err)
+			continue
+		}
+
+		for _, slot := range slots {
+			if slot.Slot_Type != "logical" {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(lagDesc, prometheus.GaugeValue, float64(slot.Lag_Bytes), inst.Name, slot.Slot_Name)
+		}
+	}
+}
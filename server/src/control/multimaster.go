@@ -0,0 +1,145 @@
+package control
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+)
+
+// Makes every instance publish and subscribe to every other instance.
+// The subscriptions skip the initial copy, so the instances should start out with the same data.
+// Each instance loads the schema's seed data by itself.
+func (c *ControlPlane) SetupMultiMaster(ctx context.Context, insts []Instance) error {
+	if len(insts) < 2 {
+		return errorf(ErrInvalid, "multi-master needs at least two instances")
+	}
+
+	for i, inst1 := range insts {
+		for _, inst2 := range insts[i+1:] {
+			connected, err := c.GetConnection(ctx, inst1, inst2)
+			if err != nil {
+				return err
+			}
+			if !connected {
+				return errorf(ErrUnreachable, "%v is not connected to %v", inst1.Name, inst2.Name)
+			}
+		}
+	}
+
+	schema := c.Schema()
+
+	for _, inst := range insts {
+		published, err := isPrimary(ctx, inst)
+		if err != nil {
+			return err
+		}
+		if published {
+			continue
+		}
+
+		err = c.SetupPrimary(ctx, inst, schema)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, inst := range insts {
+		for _, peer := range insts {
+			if inst.Name == peer.Name {
+				continue
+			}
+
+			err := c.SetupStandby(ctx, inst, peer, WithoutCopy(), OnlyLocalChanges(), WithPublications(schema.PublicationNames()...))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	slog.InfoContext(ctx, "multi-master setup", "op", "setup_multimaster", "instances", len(insts))
+	return nil
+}
+
+// A key that doesn't have the same value everywhere.
+// Values maps instance names to their value, nil when the instance doesn't have the key.
+type Divergence struct {
+	Key    string
+	Values map[string]*string
+}
+
+type Conflicts struct {
+	Divergences []Divergence
+
+	// subscriptions that stopped because of an error, by instance name
+	Failed map[string][]Subscription
+}
+
+// Compares the data on the instances and looks for subscriptions stopped by conflicts.
+// Concurrent writes to the same key either stop a subscription or silently leave the instances with different values.
+func (c *ControlPlane) DetectConflicts(ctx context.Context, insts []Instance) (Conflicts, error) {
+	ret := Conflicts{
+		Divergences: make([]Divergence, 0),
+		Failed:      make(map[string][]Subscription),
+	}
+
+	values := make(map[string]map[string]*string)
+	for _, inst := range insts {
+		kvs, err := Get(ctx, inst)
+		if err != nil {
+			return Conflicts{}, err
+		}
+
+		for _, kv := range kvs {
+			if values[kv.Key] == nil {
+				values[kv.Key] = make(map[string]*string)
+			}
+			value := kv.Value
+			values[kv.Key][inst.Name] = &value
+		}
+
+		subs, err := c.GetSubscriptions(ctx, inst)
+		if err != nil {
+			return Conflicts{}, err
+		}
+
+		for _, sub := range subs {
+			if !sub.Subenabled && sub.Last_Error != nil {
+				ret.Failed[inst.Name] = append(ret.Failed[inst.Name], sub)
+			}
+		}
+	}
+
+	for key, found := range values {
+		diverged := len(found) != len(insts)
+
+		var first *string
+		for _, v := range found {
+			if first == nil {
+				first = v
+			} else if *first != *v {
+				diverged = true
+			}
+		}
+
+		if !diverged {
+			continue
+		}
+
+		for _, inst := range insts {
+			if _, ok := found[inst.Name]; !ok {
+				found[inst.Name] = nil
+			}
+		}
+
+		ret.Divergences = append(ret.Divergences, Divergence{
+			Key:    key,
+			Values: found,
+		})
+	}
+
+	sort.Slice(ret.Divergences, func(i, j int) bool {
+		return ret.Divergences[i].Key < ret.Divergences[j].Key
+	})
+
+	return ret, nil
+}
@@ -0,0 +1,43 @@
+package control
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Instance names end up as docker container, network and volume names, as the hostname standbys connect to,
+// and in the names of subscriptions and replication slots, which postgres limits to 63 bytes.
+// A subscription name holds two instance names, see subscriptionName.
+const MAX_NAME_LENGTH = 28
+
+// lowercase letters and digits, with single dashes in between
+var instanceName = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// FullName returns the container name of an instance, names that already carry PREFIX are kept as they are.
+func FullName(name string) string {
+	if strings.HasPrefix(name, PREFIX) {
+		return name
+	}
+	return PREFIX + name
+}
+
+// ShortName returns the instance name without PREFIX.
+func ShortName(name string) string {
+	return strings.TrimPrefix(name, PREFIX)
+}
+
+// ValidateName checks a name for a new instance, with or without PREFIX.
+func ValidateName(name string) error {
+	short := ShortName(name)
+	if !instanceName.MatchString(short) {
+		return errorf(ErrInvalid, "invalid instance name %q, use lowercase letters, digits and single dashes between them", short)
+	}
+	if len(short) > MAX_NAME_LENGTH {
+		return errorf(ErrInvalid, "instance name %q is longer than %v characters", short, MAX_NAME_LENGTH)
+	}
+	// the data volume would be taken for a snapshot
+	if strings.HasPrefix(FullName(name), SNAPSHOT_PREFIX) {
+		return errorf(ErrInvalid, "instance names starting with %q are reserved for snapshots", strings.TrimPrefix(SNAPSHOT_PREFIX, PREFIX))
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// logical replication does not work without it, so it can't be overridden.
+var RESERVED_PARAMETERS = map[string]string{
+	"wal_level": "logical",
+}
+
+var parameterName = regexp.MustCompile(`^[a-z_][a-z0-9_]*(\.[a-z_][a-z0-9_]*)?$`)
+
+func ValidateParameters(params map[string]string) error {
+	for name, value := range params {
+		if !parameterName.MatchString(name) {
+			return errorf(ErrInvalid, "invalid parameter name %q", name)
+		}
+		if _, ok := RESERVED_PARAMETERS[name]; ok {
+			return errorf(ErrInvalid, "parameter %v is managed by netpart", name)
+		}
+		if value == "" {
+			return errorf(ErrInvalid, "empty value for parameter %v", name)
+		}
+		if strings.ContainsFunc(value, func(r rune) bool { return r < ' ' }) {
+			return errorf(ErrInvalid, "invalid value for parameter %v", name)
+		}
+	}
+	return nil
+}
+
+func postgresCmd(params map[string]string) []string {
+	cmd := []string{"postgres"}
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for name, value := range RESERVED_PARAMETERS {
+		cmd = append(cmd, "-c", name+"="+value)
+	}
+	for _, name := range names {
+		cmd = append(cmd, "-c", name+"="+params[name])
+	}
+
+	return cmd
+}
+
+type Parameter struct {
+	Name            string
+	Setting         string
+	Unit            string
+	Context         string
+	Source          string
+	Pending_Restart bool
+}
+
+// Lists every parameter that was changed from its built-in default.
+func GetParameters(ctx context.Context, inst Instance) ([]Parameter, error) {
+	conn, err := getConn(ctx, inst.Port)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close(ctx)
+
+	rows, err := conn.Query(ctx, "SELECT name, setting, coalesce(unit, '') AS unit, context, source, pending_restart FROM pg_settings WHERE source NOT IN ('default', 'override') ORDER BY name ASC;")
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := pgx.CollectRows(rows, pgx.RowToStructByName[Parameter])
+	if err != nil {
+		return nil, err
+	}
+
+	return params, nil
+}
+
+// Changes parameters on a running instance through ALTER SYSTEM.
+// Only parameters that take effect on reload are accepted.
+func SetParameters(ctx context.Context, inst Instance, params map[string]string) error {
+	err := ValidateParameters(params)
+	if err != nil {
+		return err
+	}
+
+	conn, err := getConn(ctx, inst.Port)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close(ctx)
+
+	for name := range params {
+		var paramContext string
+		err := conn.QueryRow(ctx, "SELECT context FROM pg_settings WHERE name = $1", name).Scan(&paramContext)
+		if err == pgx.ErrNoRows {
+			return errorf(ErrInvalid, "unknown parameter %v", name)
+		}
+		if err != nil {
+			return err
+		}
+
+		if paramContext == "postmaster" || paramContext == "internal" {
+			return errorf(ErrInvalid, "parameter %v cannot be changed without a restart", name)
+		}
+	}
+
+	for name, value := range params {
+		// ALTER SYSTEM can't take bind parameters, so let postgres do the quoting.
+		var stmt string
+		err := conn.QueryRow(ctx, "SELECT format('ALTER SYSTEM SET %I = %L', $1::text, $2::text)", name, value).Scan(&stmt)
+		if err != nil {
+			return err
+		}
+
+		_, err = conn.Exec(ctx, stmt)
+		if strings.HasPrefix(pgCode(err), "22") {
+			return &kindError{kind: ErrInvalid, err: fmt.Errorf("invalid value for parameter %v: %w", name, err)}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = conn.Exec(ctx, "SELECT pg_reload_conf();")
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "parameters reloaded", "op", "set_parameters", "instance", inst)
+	return nil
+}
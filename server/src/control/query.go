@@ -0,0 +1,131 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const DEFAULT_QUERY_TIMEOUT = 5 * time.Second
+const MAX_QUERY_TIMEOUT = time.Minute
+
+// rows past this are dropped from the result
+const MAX_QUERY_ROWS = 1000
+
+type QueryOptions struct {
+	Timeout  time.Duration // DEFAULT_QUERY_TIMEOUT when zero
+	ReadOnly bool
+}
+
+// Values are in postgres' text format, nil for NULL.
+type QueryResult struct {
+	Columns   []string
+	Rows      [][]*string
+	Command   string // the command tag, e.g. INSERT 0 1
+	Truncated bool
+}
+
+// Runs a single statement in its own transaction.
+// The statement is cancelled by postgres once the timeout passes.
+// Strings holding more than one statement are refused, as a later one could COMMIT and run outside the transaction.
+func Query(ctx context.Context, inst Instance, sql string, opts QueryOptions) (QueryResult, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = DEFAULT_QUERY_TIMEOUT
+	}
+	if opts.Timeout < 0 || opts.Timeout > MAX_QUERY_TIMEOUT {
+		return QueryResult{}, errorf(ErrInvalid, "timeout must be between 0 and %v", MAX_QUERY_TIMEOUT)
+	}
+
+	// getConn keeps retrying, so paused instances need a bound too
+	ctx, cancel := context.WithTimeout(ctx, 2*opts.Timeout)
+	defer cancel()
+
+	conn, err := getConn(ctx, inst.Port)
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	defer conn.Close(context.Background())
+
+	txOpts := pgx.TxOptions{}
+	if opts.ReadOnly {
+		txOpts.AccessMode = pgx.ReadOnly
+	}
+
+	tx, err := conn.BeginTx(ctx, txOpts)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	defer tx.Rollback(context.Background())
+
+	_, err = tx.Exec(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", opts.Timeout.Milliseconds()))
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	// the extended protocol takes one statement only.
+	// without arguments nothing is prepared, and every value comes back as text, whatever its type.
+	rows, err := tx.Query(ctx, sql, pgx.QueryExecModeExec)
+	if err != nil {
+		return QueryResult{}, queryError(err)
+	}
+
+	ret := QueryResult{
+		Columns: make([]string, 0),
+		Rows:    make([][]*string, 0),
+	}
+	for _, field := range rows.FieldDescriptions() {
+		ret.Columns = append(ret.Columns, field.Name)
+	}
+
+	for rows.Next() {
+		if len(ret.Rows) == MAX_QUERY_ROWS {
+			ret.Truncated = true
+			continue
+		}
+
+		raw := rows.RawValues()
+		row := make([]*string, len(raw))
+		for i, val := range raw {
+			if val != nil {
+				s := string(val)
+				row[i] = &s
+			}
+		}
+		ret.Rows = append(ret.Rows, row)
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return QueryResult{}, queryError(err)
+	}
+	ret.Command = rows.CommandTag().String()
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return QueryResult{}, queryError(err)
+	}
+
+	slog.InfoContext(ctx, "ran query", "op", "query", "instance", inst, "command", ret.Command)
+	return ret, nil
+}
+
+// Errors postgres raises about the syntax, data or privileges of the statement are the caller's doing,
+// as is a write in a read only query. Others, e.g. postgres shutting down, keep their kind or have none.
+func queryError(err error) error {
+	if KindOf(err) != nil {
+		return err
+	}
+
+	code := pgCode(err)
+	switch {
+	case strings.HasPrefix(code, "42"), strings.HasPrefix(code, "22"), code == "25006": // 25006 is read_only_sql_transaction
+		return &kindError{kind: ErrInvalid, err: err}
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+package control
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/docker/docker/api/types/blkiodev"
+	"github.com/docker/docker/api/types/container"
+)
+
+// docker refuses memory limits below this.
+const MIN_MEMORY = 6 * 1024 * 1024
+
+// Zero values mean unlimited on creation and unchanged on update.
+type Resources struct {
+	CPUs        float64 // fractional number of cpus
+	Memory      int64   // in bytes, swap is disabled when set
+	BlkioWeight uint16  // relative weight, between 10 and 1000
+
+	// throttles for a single block device, e.g. /dev/sda.
+	// these can only be set when the instance is created.
+	BlkioDevice string
+	ReadBps     uint64
+	WriteBps    uint64
+	ReadIOps    uint64
+	WriteIOps   uint64
+}
+
+func ValidateResources(res Resources) error {
+	if res.CPUs < 0 {
+		return errorf(ErrInvalid, "cpus cannot be negative")
+	}
+	if res.Memory != 0 && res.Memory < MIN_MEMORY {
+		return errorf(ErrInvalid, "memory limit must be at least %v bytes", MIN_MEMORY)
+	}
+	if res.BlkioWeight != 0 && (res.BlkioWeight < 10 || res.BlkioWeight > 1000) {
+		return errorf(ErrInvalid, "blkio weight must be between 10 and 1000")
+	}
+	if res.BlkioDevice == "" && res.hasThrottles() {
+		return errorf(ErrInvalid, "io throttles need a block device")
+	}
+	return nil
+}
+
+func (res Resources) hasThrottles() bool {
+	return res.ReadBps != 0 || res.WriteBps != 0 || res.ReadIOps != 0 || res.WriteIOps != 0
+}
+
+func (res Resources) toDocker() container.Resources {
+	ret := container.Resources{
+		NanoCPUs:    int64(res.CPUs * 1e9),
+		Memory:      res.Memory,
+		MemorySwap:  res.Memory,
+		BlkioWeight: res.BlkioWeight,
+	}
+
+	throttle := func(rate uint64) []*blkiodev.ThrottleDevice {
+		if rate == 0 {
+			return nil
+		}
+		return []*blkiodev.ThrottleDevice{{Path: res.BlkioDevice, Rate: rate}}
+	}
+
+	ret.BlkioDeviceReadBps = throttle(res.ReadBps)
+	ret.BlkioDeviceWriteBps = throttle(res.WriteBps)
+	ret.BlkioDeviceReadIOps = throttle(res.ReadIOps)
+	ret.BlkioDeviceWriteIOps = throttle(res.WriteIOps)
+
+	return ret
+}
+
+func resourcesFromDocker(res container.Resources) Resources {
+	ret := Resources{
+		CPUs:        float64(res.NanoCPUs) / 1e9,
+		Memory:      res.Memory,
+		BlkioWeight: res.BlkioWeight,
+	}
+
+	throttle := func(devices []*blkiodev.ThrottleDevice) uint64 {
+		if len(devices) == 0 {
+			return 0
+		}
+		ret.BlkioDevice = devices[0].Path
+		return devices[0].Rate
+	}
+
+	ret.ReadBps = throttle(res.BlkioDeviceReadBps)
+	ret.WriteBps = throttle(res.BlkioDeviceWriteBps)
+	ret.ReadIOps = throttle(res.BlkioDeviceReadIOps)
+	ret.WriteIOps = throttle(res.BlkioDeviceWriteIOps)
+
+	return ret
+}
+
+func (c *ControlPlane) GetResources(ctx context.Context, inst Instance) (Resources, error) {
+	res, err := c.cli.ContainerInspect(ctx, inst.ContainerID)
+	if err != nil {
+		return Resources{}, err
+	}
+
+	return resourcesFromDocker(res.HostConfig.Resources), nil
+}
+
+// Changes the limits of a running instance.
+// Docker can't update io throttles after creation, so only the blkio weight applies to io.
+func (c *ControlPlane) UpdateResources(ctx context.Context, inst Instance, res Resources) error {
+	err := ValidateResources(res)
+	if err != nil {
+		return err
+	}
+
+	if res.BlkioDevice != "" || res.hasThrottles() {
+		return errorf(ErrInvalid, "io throttles can only be set when creating an instance")
+	}
+
+	_, err = c.cli.ContainerUpdate(ctx, inst.ContainerID, container.UpdateConfig{
+		Resources: res.toDocker(),
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "updated resources", "op", "update_resources", "instance", inst)
+	return nil
+}
@@ -0,0 +1,126 @@
+package control
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type PublicationTable struct {
+	Name    string   // optionally schema qualified, e.g. public.kv
+	Columns []string // every column when empty
+	Where   string   // row filter, every row when empty
+}
+
+type Publication struct {
+	Name   string
+	Tables []PublicationTable // every table when empty
+}
+
+// What gets replicated. DDL runs on every new instance, Seed and Publications on primaries.
+// DDL also runs when an instance reuses a kept volume, so it should be safe to run twice.
+type Schema struct {
+	DDL          string
+	Seed         string
+	Publications []Publication
+}
+
+// Get and Put only work with schemas that keep the kv table from here.
+var DefaultSchema = Schema{
+	DDL: DDL,
+	Publications: []Publication{
+		{
+			Name:   PUB_NAME,
+			Tables: []PublicationTable{{Name: "kv"}},
+		},
+	},
+}
+
+func ValidateSchema(schema Schema) error {
+	if strings.TrimSpace(schema.DDL) == "" {
+		return errorf(ErrInvalid, "schema needs ddl")
+	}
+
+	seen := make(map[string]bool)
+	for _, pub := range schema.Publications {
+		if pub.Name == "" {
+			return errorf(ErrInvalid, "publication needs a name")
+		}
+		if seen[pub.Name] {
+			return errorf(ErrInvalid, "duplicate publication %v", pub.Name)
+		}
+		seen[pub.Name] = true
+
+		for _, table := range pub.Tables {
+			if table.Name == "" {
+				return errorf(ErrInvalid, "publication %v has a table without a name", pub.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (schema Schema) PublicationNames() []string {
+	names := make([]string, len(schema.Publications))
+	for i, pub := range schema.Publications {
+		names[i] = pub.Name
+	}
+	return names
+}
+
+// Row filters are raw SQL, same as the DDL.
+func (pub Publication) createStatement() string {
+	var sb strings.Builder
+	sb.WriteString("CREATE PUBLICATION ")
+	sb.WriteString(pgx.Identifier{pub.Name}.Sanitize())
+
+	if len(pub.Tables) == 0 {
+		sb.WriteString(" FOR ALL TABLES")
+		return sb.String()
+	}
+
+	sb.WriteString(" FOR TABLE ")
+	for i, table := range pub.Tables {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(pgx.Identifier(strings.Split(table.Name, ".")).Sanitize())
+
+		if len(table.Columns) > 0 {
+			cols := make([]string, len(table.Columns))
+			for j, col := range table.Columns {
+				cols[j] = pgx.Identifier{col}.Sanitize()
+			}
+			sb.WriteString(" (" + strings.Join(cols, ", ") + ")")
+		}
+
+		if table.Where != "" {
+			sb.WriteString(" WHERE (" + table.Where + ")")
+		}
+	}
+
+	return sb.String()
+}
+
+func (c *ControlPlane) Schema() Schema {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.schema
+}
+
+// Replaces the schema used for instances added from now on.
+func (c *ControlPlane) SetSchema(schema Schema) error {
+	err := ValidateSchema(schema)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.schema = schema
+
+	slog.Info("schema updated", "op", "set_schema", "publications", len(schema.Publications))
+	return nil
+}
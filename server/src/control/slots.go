@@ -0,0 +1,105 @@
+package control
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type Slot struct {
+	Slot_Name           string
+	Plugin              string
+	Slot_Type           string
+	Active              bool
+	Restart_Lsn         string
+	Confirmed_Flush_Lsn string
+	Retained_Bytes      int64 // WAL the slot keeps from being recycled
+	Lag_Bytes           int64 // WAL the subscriber has yet to confirm
+	Wal_Status          string
+}
+
+func ListSlots(ctx context.Context, inst Instance) ([]Slot, error) {
+	conn, err := getConn(ctx, inst.Port)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close(ctx)
+
+	rows, err := conn.Query(ctx, `SELECT
+		slot_name,
+		coalesce(plugin, '') AS plugin,
+		slot_type,
+		active,
+		coalesce(restart_lsn::text, '') AS restart_lsn,
+		coalesce(confirmed_flush_lsn::text, '') AS confirmed_flush_lsn,
+		coalesce(pg_wal_lsn_diff(pg_current_wal_lsn(), restart_lsn), 0)::bigint AS retained_bytes,
+		coalesce(pg_wal_lsn_diff(pg_current_wal_lsn(), confirmed_flush_lsn), 0)::bigint AS lag_bytes,
+		coalesce(wal_status, '') AS wal_status
+	FROM pg_replication_slots ORDER BY slot_name ASC;`)
+	if err != nil {
+		return nil, err
+	}
+
+	slots, err := pgx.CollectRows(rows, pgx.RowToStructByName[Slot])
+	if err != nil {
+		return nil, err
+	}
+
+	return slots, nil
+}
+
+// Drops the slot so it stops holding back WAL.
+// Slots in use are refused unless forced, in which case whoever is streaming from it gets kicked out.
+// A partitioned walsender holds on to its slot until wal_sender_timeout otherwise.
+func DropSlot(ctx context.Context, inst Instance, slot string, force bool) error {
+	conn, err := getConn(ctx, inst.Port)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close(ctx)
+
+	for {
+		var active bool
+		err := conn.QueryRow(ctx, "SELECT active FROM pg_replication_slots WHERE slot_name = $1", slot).Scan(&active)
+		if err == pgx.ErrNoRows {
+			if force {
+				return nil
+			}
+			return errorf(ErrNotFound, "cannot find slot %v", slot)
+		}
+		if err != nil {
+			return err
+		}
+
+		if !active {
+			break
+		}
+
+		if !force {
+			return errorf(ErrConflict, "slot %v is in use", slot)
+		}
+
+		_, err = conn.Exec(ctx, "SELECT pg_terminate_backend(active_pid) FROM pg_replication_slots WHERE slot_name = $1 AND active", slot)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	_, err = conn.Exec(ctx, "SELECT pg_drop_replication_slot($1)", slot)
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "dropped slot", "op", "drop_slot", "instance", inst, "slot", slot)
+	return nil
+}
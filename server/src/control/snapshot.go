@@ -0,0 +1,275 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/jackc/pgx/v5"
+)
+
+// marks volumes that hold a snapshot, the value is the snapshotted instance.
+// these are kept by Cleanup.
+const SNAPSHOT_LABEL = "netpart.snapshot"
+
+const SNAPSHOT_PREFIX = PREFIX + "snapshot-"
+
+var snapshotName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+type Snapshot struct {
+	Name      string
+	Source    string
+	Volume    string
+	CreatedAt string
+}
+
+func snapshotFromVolume(vol *volume.Volume) Snapshot {
+	return Snapshot{
+		Name:      strings.TrimPrefix(vol.Name, SNAPSHOT_PREFIX),
+		Source:    vol.Labels[SNAPSHOT_LABEL],
+		Volume:    vol.Name,
+		CreatedAt: vol.CreatedAt,
+	}
+}
+
+// Copies the data directory of an instance into a new volume.
+// Postgres is shut down for the copy so the snapshot is consistent, and started again afterwards.
+func (c *ControlPlane) CreateSnapshot(ctx context.Context, inst Instance, name string) (Snapshot, error) {
+	if !snapshotName.MatchString(name) {
+		return Snapshot{}, errorf(ErrInvalid, "invalid snapshot name %q", name)
+	}
+	if inst.Volume == "" {
+		return Snapshot{}, errorf(ErrConflict, "instance %v has no data volume", inst.Name)
+	}
+
+	_, err := c.GetSnapshot(ctx, name)
+	if err == nil {
+		return Snapshot{}, errorf(ErrAlreadyExists, "snapshot %v already exists", name)
+	}
+
+	vol, err := c.cli.VolumeCreate(ctx, volume.CreateOptions{
+		Name: SNAPSHOT_PREFIX + name,
+		Labels: map[string]string{
+			SNAPSHOT_LABEL: inst.Name,
+		},
+	})
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	err = c.whileStopped(ctx, inst, func(image string) error {
+		return c.copyVolume(ctx, image, inst.Volume, vol.Name)
+	})
+	if err != nil {
+		c.cli.VolumeRemove(ctx, vol.Name, true)
+		return Snapshot{}, err
+	}
+
+	slog.InfoContext(ctx, "snapshotted instance", "op", "create_snapshot", "instance", inst, "snapshot", name)
+	return snapshotFromVolume(&vol), nil
+}
+
+// Replaces the data directory of an instance with the snapshot.
+// Every instance replicating with it should be restored too, otherwise their positions won't line up.
+func (c *ControlPlane) RestoreSnapshot(ctx context.Context, inst Instance, snap Snapshot) (Instance, error) {
+	if inst.Volume == "" {
+		return Instance{}, errorf(ErrConflict, "instance %v has no data volume", inst.Name)
+	}
+
+	err := c.whileStopped(ctx, inst, func(image string) error {
+		return c.copyVolume(ctx, image, snap.Volume, inst.Volume)
+	})
+	if err != nil {
+		return Instance{}, err
+	}
+
+	slog.InfoContext(ctx, "restored instance", "op", "restore_snapshot", "instance", inst, "snapshot", snap.Name)
+	c.publish(EVENT_INSTANCE_RESTORED, fmt.Sprintf("restored %v from snapshot %v", inst.Name, snap.Name), inst)
+	return c.GetInstance(ctx, inst.Name)
+}
+
+func (c *ControlPlane) GetSnapshot(ctx context.Context, name string) (Snapshot, error) {
+	snaps, err := c.ListSnapshots(ctx)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	for _, s := range snaps {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+
+	return Snapshot{}, errorf(ErrNotFound, "cannot find snapshot %v", name)
+}
+
+func (c *ControlPlane) ListSnapshots(ctx context.Context) ([]Snapshot, error) {
+	volumes, err := c.cli.VolumeList(ctx, volume.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", SNAPSHOT_LABEL)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]Snapshot, 0)
+	for _, vol := range volumes.Volumes {
+		if !strings.HasPrefix(vol.Name, SNAPSHOT_PREFIX) {
+			continue
+		}
+		ret = append(ret, snapshotFromVolume(vol))
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+
+	return ret, nil
+}
+
+func (c *ControlPlane) DeleteSnapshot(ctx context.Context, snap Snapshot) error {
+	err := c.cli.VolumeRemove(ctx, snap.Volume, false)
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "deleted snapshot", "op", "delete_snapshot", "snapshot", snap.Name)
+	return nil
+}
+
+// Runs fn while the instance's postgres is cleanly shut down.
+// fn receives the instance's image, which is guaranteed to be available for helper containers.
+func (c *ControlPlane) whileStopped(ctx context.Context, inst Instance, fn func(image string) error) error {
+	res, err := c.cli.ContainerInspect(ctx, inst.ContainerID)
+	if err != nil {
+		return err
+	}
+
+	running := res.State.Running
+	if res.State.Paused {
+		err := c.UnpauseInstance(ctx, inst)
+		if err != nil {
+			return err
+		}
+	}
+
+	if running {
+		// fast shutdown, a smart one waits for every client to leave
+		err := c.cli.ContainerStop(ctx, inst.ContainerID, container.StopOptions{
+			Signal: "SIGINT",
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	fnErr := fn(res.Config.Image)
+
+	if running {
+		err := c.cli.ContainerStart(ctx, inst.ContainerID, container.StartOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	return fnErr
+}
+
+// Replaces the contents of the volume dst with the contents of src using a throwaway container.
+func (c *ControlPlane) copyVolume(ctx context.Context, image string, src string, dst string) error {
+	ctr, err := c.cli.ContainerCreate(ctx, &container.Config{
+		Image:      image,
+		User:       "root",
+		Entrypoint: []string{"sh", "-c"},
+		Cmd:        []string{"find /to -mindepth 1 -delete && cp -a /from/. /to/"},
+	}, &container.HostConfig{
+		Mounts: []mount.Mount{
+			{
+				Type:     mount.TypeVolume,
+				Source:   src,
+				Target:   "/from",
+				ReadOnly: true,
+			},
+			{
+				Type:   mount.TypeVolume,
+				Source: dst,
+				Target: "/to",
+			},
+		},
+	}, nil, nil, "")
+	if err != nil {
+		return err
+	}
+
+	defer c.cli.ContainerRemove(context.Background(), ctr.ID, container.RemoveOptions{
+		Force:         true,
+		RemoveVolumes: true,
+	})
+
+	waitC, errC := c.cli.ContainerWait(ctx, ctr.ID, container.WaitConditionNextExit)
+
+	err = c.cli.ContainerStart(ctx, ctr.ID, container.StartOptions{})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case res := <-waitC:
+		if res.StatusCode != 0 {
+			return fmt.Errorf("copying %v to %v exited with %v", src, dst, res.StatusCode)
+		}
+	case err := <-errC:
+		return err
+	}
+
+	return nil
+}
+
+// A copied data directory still carries the replication setup of the original.
+// The copy is not connected to anything yet, so its subscriptions can be dropped without touching the upstream slots,
+// and the slots it inherited from its own subscribers are never going to be used.
+func detachReplication(ctx context.Context, inst Instance) error {
+	conn, err := getConn(ctx, inst.Port)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close(ctx)
+
+	rows, err := conn.Query(ctx, "SELECT subname FROM pg_subscription;")
+	if err != nil {
+		return err
+	}
+
+	subs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		ident := pgx.Identifier{sub}.Sanitize()
+		for _, stmt := range []string{
+			"ALTER SUBSCRIPTION " + ident + " DISABLE",
+			"ALTER SUBSCRIPTION " + ident + " SET (slot_name = NONE)",
+			"DROP SUBSCRIPTION " + ident,
+		} {
+			_, err = conn.Exec(ctx, stmt)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = conn.Exec(ctx, "SELECT pg_drop_replication_slot(slot_name) FROM pg_replication_slots WHERE NOT active;")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
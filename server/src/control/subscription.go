@@ -0,0 +1,274 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// an instance can subscribe to several others, so the name covers both ends.
+// the slot on the primary is named after the subscription too.
+// replication slot name can only be numbers, alpha, and underscores.
+// instance names can't hold underscores, so a-b and a_b can't end up with the same subscription.
+func subscriptionName(inst Instance, active Instance) string {
+	name := "sub_" + strings.TrimPrefix(inst.Name, PREFIX) + "_" + strings.TrimPrefix(active.Name, PREFIX)
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+// how instances reach each other over their docker networks.
+func connInfo(active Instance) string {
+	return fmt.Sprintf("host=%v dbname=%v user=%v password=%v", active.Name, POSTGRES_DB, POSTGRES_USER, POSTGRES_PASSWORD)
+}
+
+// Stops the standby from applying changes. The slot on the primary is kept, so the standby can catch up with RestartStandby.
+func (c *ControlPlane) DisableStandby(ctx context.Context, inst Instance, active Instance) error {
+	conn, err := getConn(ctx, inst.Port)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close(ctx)
+
+	sub := fmt.Sprintf("ALTER SUBSCRIPTION \"%v\" DISABLE", subscriptionName(inst, active))
+
+	_, err = conn.Exec(ctx, sub)
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "standby disabled", "op", "disable_standby", "instance", inst, "upstream", active)
+	c.publish(EVENT_SUBSCRIPTION_DISABLED, fmt.Sprintf("disabled %v", subscriptionName(inst, active)), inst, active)
+	return nil
+}
+
+// Drops the subscription along with its slot on the primary.
+// The slot is dropped by the control plane instead of the standby, so this works even when the two are partitioned.
+func (c *ControlPlane) DropStandby(ctx context.Context, inst Instance, active Instance) error {
+	conn, err := getConn(ctx, inst.Port)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close(ctx)
+
+	name := subscriptionName(inst, active)
+
+	var slot *string
+	err = conn.QueryRow(ctx, "SELECT subslotname FROM pg_subscription WHERE subname = $1", name).Scan(&slot)
+	if err == pgx.ErrNoRows {
+		return errorf(ErrConflict, "%v is not a standby", inst.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	// detaching the slot stops DROP SUBSCRIPTION from reaching out to the primary
+	for _, stmt := range []string{
+		fmt.Sprintf("ALTER SUBSCRIPTION \"%v\" DISABLE", name),
+		fmt.Sprintf("ALTER SUBSCRIPTION \"%v\" SET (slot_name = NONE)", name),
+		fmt.Sprintf("DROP SUBSCRIPTION \"%v\"", name),
+	} {
+		_, err = conn.Exec(ctx, stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.InfoContext(ctx, "standby dropped", "op", "drop_standby", "instance", inst, "upstream", active)
+	c.publish(EVENT_ROLE_DROPPED, fmt.Sprintf("%v is no longer a standby of %v", inst.Name, active.Name), inst, active)
+
+	if slot == nil {
+		return nil
+	}
+
+	return DropSlot(ctx, active, *slot, true)
+}
+
+// Points the standby of one primary at a different primary, e.g. after a failover.
+// The subscription and its slot get renamed after the new primary.
+// The old primary is left alone since it is usually unreachable at this point, so its slot has to be dropped separately.
+func (c *ControlPlane) RepointStandby(ctx context.Context, inst Instance, from Instance, active Instance) error {
+	conn, err := getConn(ctx, inst.Port)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close(ctx)
+
+	old_name := subscriptionName(inst, from)
+	name := subscriptionName(inst, active)
+
+	var exists bool
+	err = conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_subscription WHERE subname = $1)", old_name).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errorf(ErrConflict, "%v is not a standby of %v", inst.Name, from.Name)
+	}
+
+	err = prepareSlot(ctx, active, name)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range []string{
+		fmt.Sprintf("ALTER SUBSCRIPTION \"%v\" DISABLE", old_name),
+		fmt.Sprintf("ALTER SUBSCRIPTION \"%v\" CONNECTION '%v'", old_name, connInfo(active)),
+		fmt.Sprintf("ALTER SUBSCRIPTION \"%v\" SET (slot_name = '%v')", old_name, name),
+		fmt.Sprintf("ALTER SUBSCRIPTION \"%v\" RENAME TO \"%v\"", old_name, name),
+		fmt.Sprintf("ALTER SUBSCRIPTION \"%v\" ENABLE", name),
+	} {
+		_, err = conn.Exec(ctx, stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.InfoContext(ctx, "standby repointed", "op", "repoint_standby", "instance", inst, "from", from, "upstream", active)
+	c.publish(EVENT_ROLE_REPOINTED, fmt.Sprintf("%v moved from %v to %v", inst.Name, from.Name, active.Name), inst, active, from)
+	return nil
+}
+
+// Picks up tables that were added to the publication after the subscription was made.
+func RefreshStandby(ctx context.Context, inst Instance, active Instance) error {
+	conn, err := getConn(ctx, inst.Port)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close(ctx)
+
+	sub := fmt.Sprintf("ALTER SUBSCRIPTION \"%v\" REFRESH PUBLICATION", subscriptionName(inst, active))
+
+	_, err = conn.Exec(ctx, sub)
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "standby refreshed", "op", "refresh_standby", "instance", inst, "upstream", active)
+	return nil
+}
+
+type SubscriptionError struct {
+	Time       string
+	Message    string
+	Detail     string
+	Context    string
+	Conflict   bool   // the change clashed with data already on the standby
+	Finish_Lsn string // pass to SkipTransaction to get past the failing transaction
+}
+
+type Subscription struct {
+	Subname           string
+	Subenabled        bool
+	Apply_Error_Count int64
+	Sync_Error_Count  int64
+	Last_Error        *SubscriptionError // from the server logs, nil when there is none
+}
+
+// Lists the subscriptions of an instance along with the errors that made them stop.
+// Subscriptions are made with disable_on_error, so a disabled subscription usually has an error here.
+func (c *ControlPlane) GetSubscriptions(ctx context.Context, inst Instance) ([]Subscription, error) {
+	conn, err := getConn(ctx, inst.Port)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close(ctx)
+
+	rows, err := conn.Query(ctx, `SELECT
+		s.subname,
+		s.subenabled,
+		coalesce(st.apply_error_count, 0) AS apply_error_count,
+		coalesce(st.sync_error_count, 0) AS sync_error_count
+	FROM pg_subscription s LEFT JOIN pg_stat_subscription_stats st ON st.subid = s.oid
+	ORDER BY s.subname ASC;`)
+	if err != nil {
+		return nil, err
+	}
+
+	subs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Subscription, error) {
+		var sub Subscription
+		err := row.Scan(&sub.Subname, &sub.Subenabled, &sub.Apply_Error_Count, &sub.Sync_Error_Count)
+		return sub, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logs, err := c.readLogs(ctx, inst, "5000")
+	if err != nil {
+		return nil, err
+	}
+
+	errs := parseSubscriptionErrors(logs)
+	for i := range subs {
+		if last, ok := errs[subs[i].Subname]; ok {
+			subs[i].Last_Error = &last
+		}
+	}
+
+	return subs, nil
+}
+
+var lsnFormat = regexp.MustCompile(`^[0-9A-Fa-f]+/[0-9A-Fa-f]+$`)
+
+// Throws away the remote transaction that finishes at lsn and enables the subscription again.
+// This is how conflicts are resolved in favor of what the standby already has.
+func (c *ControlPlane) SkipTransaction(ctx context.Context, inst Instance, subscription string, lsn string) error {
+	if !lsnFormat.MatchString(lsn) {
+		return errorf(ErrInvalid, "invalid lsn %q", lsn)
+	}
+
+	conn, err := getConn(ctx, inst.Port)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close(ctx)
+
+	ident := pgx.Identifier{subscription}.Sanitize()
+	for _, stmt := range []string{
+		fmt.Sprintf("ALTER SUBSCRIPTION %v SKIP (lsn = '%v')", ident, lsn),
+		fmt.Sprintf("ALTER SUBSCRIPTION %v ENABLE", ident),
+	} {
+		_, err = conn.Exec(ctx, stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.InfoContext(ctx, "skipped transaction", "op", "skip_transaction", "instance", inst, "subscription", subscription, "lsn", lsn)
+	c.publish(EVENT_SUBSCRIPTION_ENABLED, fmt.Sprintf("enabled %v after skipping the transaction at %v", subscription, lsn), inst)
+	return nil
+}
+
+// Makes sure the primary can serve a subscription that uses the given slot.
+func prepareSlot(ctx context.Context, active Instance, slot string) error {
+	published, err := isPrimary(ctx, active)
+	if err != nil {
+		return err
+	}
+	if !published {
+		return errorf(ErrConflict, "%v is not a primary", active.Name)
+	}
+
+	conn, err := getConn(ctx, active.Port)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close(ctx)
+
+	_, err = conn.Exec(ctx, "SELECT pg_create_logical_replication_slot($1, 'pgoutput') WHERE NOT EXISTS (SELECT 1 FROM pg_replication_slots WHERE slot_name = $1)", slot)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
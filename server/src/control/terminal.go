@@ -0,0 +1,68 @@
+package control
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// An interactive psql session inside an instance, attached to a TTY.
+// Reads return the raw terminal output and writes are typed into it.
+type Terminal struct {
+	c      *ControlPlane
+	execID string
+	hijack types.HijackedResponse
+}
+
+func (c *ControlPlane) OpenTerminal(ctx context.Context, inst Instance, cols uint, rows uint) (*Terminal, error) {
+	exec, err := c.cli.ContainerExecCreate(ctx, inst.ContainerID, container.ExecOptions{
+		Cmd:          []string{"psql", "-U", POSTGRES_USER, "-d", POSTGRES_DB},
+		Env:          []string{"TERM=xterm-256color"},
+		Tty:          true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		ConsoleSize:  &[2]uint{rows, cols},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hijack, err := c.cli.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{
+		Tty: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "opened terminal", "op", "open_terminal", "instance", inst)
+	return &Terminal{
+		c:      c,
+		execID: exec.ID,
+		hijack: hijack,
+	}, nil
+}
+
+// with a TTY, stdout and stderr come through as a single stream
+func (t *Terminal) Read(p []byte) (int, error) {
+	return t.hijack.Reader.Read(p)
+}
+
+func (t *Terminal) Write(p []byte) (int, error) {
+	return t.hijack.Conn.Write(p)
+}
+
+func (t *Terminal) Resize(ctx context.Context, cols uint, rows uint) error {
+	return t.c.cli.ContainerExecResize(ctx, t.execID, container.ResizeOptions{
+		Height: rows,
+		Width:  cols,
+	})
+}
+
+// Closing the connection hangs up on psql, which then exits by itself.
+func (t *Terminal) Close() error {
+	t.hijack.Close()
+	return nil
+}
@@ -0,0 +1,161 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// A subscription, seen as data flowing from the publisher to the subscriber.
+type Link struct {
+	Publisher    string
+	Subscriber   string
+	Subscription string
+	Publications []string
+	Origin       string
+	Enabled      bool
+}
+
+type Topology struct {
+	Publishers []string
+	Links      []Link
+}
+
+var connHost = regexp.MustCompile(`host=(\S+)`)
+
+// Reads the replication setup of every running instance.
+func (c *ControlPlane) GetTopology(ctx context.Context) (Topology, error) {
+	insts, err := c.ListInstances(ctx)
+	if err != nil {
+		return Topology{}, err
+	}
+
+	ret := Topology{
+		Publishers: make([]string, 0),
+		Links:      make([]Link, 0),
+	}
+
+	for _, inst := range insts {
+		if inst.State != "running" {
+			continue
+		}
+
+		published, err := isPrimary(ctx, inst)
+		if err != nil {
+			return Topology{}, err
+		}
+		if published {
+			ret.Publishers = append(ret.Publishers, inst.Name)
+		}
+
+		links, err := getLinks(ctx, inst)
+		if err != nil {
+			return Topology{}, err
+		}
+		ret.Links = append(ret.Links, links...)
+	}
+
+	return ret, nil
+}
+
+func getLinks(ctx context.Context, inst Instance) ([]Link, error) {
+	conn, err := getConn(ctx, inst.Port)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close(ctx)
+
+	rows, err := conn.Query(ctx, "SELECT subname, subconninfo, subpublications, suborigin, subenabled FROM pg_subscription ORDER BY subname ASC;")
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Link, error) {
+		var conninfo string
+		link := Link{
+			Subscriber: inst.Name,
+		}
+
+		err := row.Scan(&link.Subscription, &conninfo, &link.Publications, &link.Origin, &link.Enabled)
+		if err != nil {
+			return link, err
+		}
+
+		if host := connHost.FindStringSubmatch(conninfo); host != nil {
+			link.Publisher = host[1]
+		}
+		return link, nil
+	})
+}
+
+// Publishes what the instance receives from its own upstream, so others can subscribe to it in a chain.
+// Unlike SetupPrimary, the seed data is left out since it arrives from upstream.
+func (c *ControlPlane) Republish(ctx context.Context, inst Instance, schema Schema) error {
+	published, err := isPrimary(ctx, inst)
+	if err != nil {
+		return err
+	}
+	if published {
+		return nil
+	}
+
+	err = setupPublications(ctx, inst, schema)
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "republishing", "op", "republish", "instance", inst)
+	c.publish(EVENT_ROLE_REPUBLISHED, fmt.Sprintf("%v now republishes what it receives", inst.Name), inst)
+	return nil
+}
+
+// Checks whether subscribing inst to active would make changes go around in circles.
+// That happens when changes from inst already reach active.
+// A link with origin none still carries its publisher's own changes, so every link out of inst counts,
+// but past that first hop changes only travel on over links with origin any.
+func (c *ControlPlane) CheckLoop(ctx context.Context, inst Instance, active Instance) error {
+	topo, err := c.GetTopology(ctx)
+	if err != nil {
+		return err
+	}
+
+	loop := errorf(ErrConflict, "%v already receives changes from %v, subscribing would loop", active.Name, inst.Name)
+	if inst.Name == active.Name {
+		return loop
+	}
+
+	forwarded := make(map[string][]string)
+	seen := map[string]bool{inst.Name: true}
+	var queue []string
+	for _, l := range topo.Links {
+		if l.Publisher == inst.Name && !seen[l.Subscriber] {
+			seen[l.Subscriber] = true
+			queue = append(queue, l.Subscriber)
+		}
+		if l.Origin != "none" {
+			forwarded[l.Publisher] = append(forwarded[l.Publisher], l.Subscriber)
+		}
+	}
+
+	for len(queue) > 0 {
+		curr := queue[0]
+		queue = queue[1:]
+
+		if curr == active.Name {
+			return loop
+		}
+
+		for _, next := range forwarded[curr] {
+			if !seen[next] {
+				seen[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,320 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how often TrackTraffic reads the counters
+const TRAFFIC_INTERVAL = 2 * time.Second
+
+type TrafficCounters struct {
+	Bytes   uint64
+	Packets uint64
+}
+
+// Traffic over the network shared by two instances, from the point of view of Instance.
+// Counters start from zero every time the two get connected.
+type LinkTraffic struct {
+	Instance  string
+	Peer      string
+	Connected bool
+
+	Sent     TrafficCounters // from Instance to Peer
+	Received TrafficCounters // from Peer to Instance
+
+	// established tcp connections to the other side's postgres
+	Outgoing int // opened by Instance
+	Incoming int // opened by Peer
+
+	LastActivity time.Time // when the counters last moved, zero if they never did
+	Idle         float64   // seconds since LastActivity
+}
+
+type trafficSample struct {
+	sent     TrafficCounters
+	received TrafficCounters
+	changed  time.Time
+}
+
+type trafficTracker struct {
+	mu      sync.Mutex
+	samples map[string]trafficSample
+}
+
+// Notes when the counters of a link last changed, if they could be read at all.
+// A reconnected link starts from zero again, which counts as a change once packets flow.
+func (t *trafficTracker) observe(link *LinkTraffic, measured bool, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.samples == nil {
+		t.samples = make(map[string]trafficSample)
+	}
+
+	key := link.Instance + "/" + link.Peer
+	prev, seen := t.samples[key]
+
+	if measured {
+		moved := !seen || prev.sent != link.Sent || prev.received != link.Received
+		active := link.Sent.Packets != 0 || link.Received.Packets != 0
+		if moved && active {
+			prev.changed = now
+		}
+		prev.sent = link.Sent
+		prev.received = link.Received
+		t.samples[key] = prev
+	}
+
+	link.LastActivity = prev.changed
+	if !prev.changed.IsZero() {
+		link.Idle = now.Sub(prev.changed).Seconds()
+	}
+}
+
+// prints a line per interface, then the tcp table after a separator
+const TRAFFIC_SCRIPT = `for i in /sys/class/net/*; do
+	echo "$(cat $i/address) $(cat $i/statistics/rx_bytes) $(cat $i/statistics/rx_packets) $(cat $i/statistics/tx_bytes) $(cat $i/statistics/tx_packets)"
+done
+echo --
+cat /proc/net/tcp`
+
+type interfaceCounters struct {
+	rx TrafficCounters
+	tx TrafficCounters
+}
+
+type tcpConn struct {
+	local      net.IP
+	localPort  int
+	remote     net.IP
+	remotePort int
+}
+
+// Reads the counters of every interface, by mac address, and the established tcp connections of a container.
+func (c *ControlPlane) readTraffic(ctx context.Context, containerID string) (map[string]interfaceCounters, []tcpConn, error) {
+	var out bytes.Buffer
+	err := c.exec(ctx, containerID, []string{"sh", "-c", TRAFFIC_SCRIPT}, nil, &out)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ifaces, tcp, found := strings.Cut(out.String(), "--\n")
+	if !found {
+		return nil, nil, fmt.Errorf("unexpected traffic output")
+	}
+
+	counters, err := parseInterfaces(ifaces)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conns, err := parseTCP(tcp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return counters, conns, nil
+}
+
+func parseInterfaces(out string) (map[string]interfaceCounters, error) {
+	ret := make(map[string]interfaceCounters)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 5 {
+			continue
+		}
+
+		nums := make([]uint64, 4)
+		for i, f := range fields[1:] {
+			n, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid interface counter %q", f)
+			}
+			nums[i] = n
+		}
+
+		ret[fields[0]] = interfaceCounters{
+			rx: TrafficCounters{Bytes: nums[0], Packets: nums[1]},
+			tx: TrafficCounters{Bytes: nums[2], Packets: nums[3]},
+		}
+	}
+	return ret, nil
+}
+
+// the st column of /proc/net/tcp
+const TCP_ESTABLISHED = "01"
+
+// Parses /proc/net/tcp, keeping only established connections.
+// Addresses are written as hex, with the ip in host byte order.
+func parseTCP(out string) ([]tcpConn, error) {
+	parseAddr := func(s string) (net.IP, int, error) {
+		ipHex, portHex, found := strings.Cut(s, ":")
+		if !found {
+			return nil, 0, fmt.Errorf("invalid address %q", s)
+		}
+
+		b, err := hex.DecodeString(ipHex)
+		if err != nil || len(b) != 4 {
+			return nil, 0, fmt.Errorf("invalid address %q", s)
+		}
+
+		port, err := strconv.ParseUint(portHex, 16, 16)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid address %q", s)
+		}
+
+		return net.IPv4(b[3], b[2], b[1], b[0]), int(port), nil
+	}
+
+	ret := make([]tcpConn, 0)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] == "sl" || fields[3] != TCP_ESTABLISHED {
+			continue
+		}
+
+		local, localPort, err := parseAddr(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		remote, remotePort, err := parseAddr(fields[2])
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, tcpConn{
+			local:      local,
+			localPort:  localPort,
+			remote:     remote,
+			remotePort: remotePort,
+		})
+	}
+	return ret, nil
+}
+
+// Reads the traffic between every pair of instances.
+// The link between two instances is the lower one's network, which the higher one joins on Connect,
+// so the higher one's interface on that network only carries traffic between the two.
+// Paused and stopped instances can't be read, their links are reported without counters.
+func (c *ControlPlane) GetTraffic(ctx context.Context) ([]LinkTraffic, error) {
+	insts, err := c.ListInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// endpoint of each instance on each network, by instance and network name
+	type endpoint struct {
+		ip  net.IP
+		mac string
+	}
+	endpoints := make(map[string]map[string]endpoint)
+	for _, inst := range insts {
+		res, err := c.cli.ContainerInspect(ctx, inst.ContainerID)
+		if err != nil {
+			return nil, err
+		}
+
+		endpoints[inst.Name] = make(map[string]endpoint)
+		for name, n := range res.NetworkSettings.Networks {
+			endpoints[inst.Name][name] = endpoint{
+				ip:  net.ParseIP(n.IPAddress),
+				mac: n.MacAddress,
+			}
+		}
+	}
+
+	type reading struct {
+		counters map[string]interfaceCounters
+		conns    []tcpConn
+	}
+	readings := make(map[string]reading)
+	read := func(inst Instance) (reading, bool) {
+		if r, ok := readings[inst.Name]; ok {
+			return r, r.counters != nil
+		}
+
+		var r reading
+		if inst.State == "running" {
+			counters, conns, err := c.readTraffic(ctx, inst.ContainerID)
+			if err != nil {
+				slog.WarnContext(ctx, "cannot read traffic", "op", "get_traffic", "instance", inst, "err", err)
+			} else {
+				r = reading{counters: counters, conns: conns}
+			}
+		}
+		readings[inst.Name] = r
+		return r, r.counters != nil
+	}
+
+	now := time.Now()
+	ret := make([]LinkTraffic, 0)
+	for i, lower := range insts {
+		for _, higher := range insts[i+1:] {
+			link := LinkTraffic{
+				Instance: lower.Name,
+				Peer:     higher.Name,
+			}
+
+			ep, connected := endpoints[higher.Name][lower.Name]
+			link.Connected = connected
+
+			measured := false
+			if connected {
+				if r, ok := read(higher); ok {
+					measured = true
+					// the higher one receives what the lower one sends
+					counters := r.counters[ep.mac]
+					link.Sent = counters.rx
+					link.Received = counters.tx
+
+					lowerIP := endpoints[lower.Name][lower.Name].ip
+					for _, conn := range r.conns {
+						if !conn.remote.Equal(lowerIP) {
+							continue
+						}
+						if conn.localPort == 5432 {
+							link.Outgoing++
+						} else if conn.remotePort == 5432 {
+							link.Incoming++
+						}
+					}
+				}
+			}
+
+			c.traffic.observe(&link, measured, now)
+			ret = append(ret, link)
+		}
+	}
+
+	return ret, nil
+}
+
+// Keeps reading the traffic until ctx is done, so LastActivity stays accurate even when nobody asks for it.
+func (c *ControlPlane) TrackTraffic(ctx context.Context) {
+	ticker := time.NewTicker(TRAFFIC_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		readCtx, cancel := context.WithTimeout(ctx, TRAFFIC_INTERVAL)
+		_, err := c.GetTraffic(readCtx)
+		cancel()
+		if err != nil {
+			slog.DebugContext(ctx, "cannot track traffic", "op", "track_traffic", "err", err)
+		}
+	}
+}
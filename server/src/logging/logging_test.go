@@ -0,0 +1,53 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"netpart/logging"
+	"testing"
+)
+
+func TestContextAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "debug", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := logging.With(context.Background(), "request_id", "abc")
+	logger.DebugContext(ctx, "hello", "op", "test")
+
+	var line map[string]any
+	err = json.Unmarshal(buf.Bytes(), &line)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if line["request_id"] != "abc" || line["op"] != "test" {
+		t.Fatalf("missing attributes in %v", buf.String())
+	}
+}
+
+func TestLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "warn", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("hidden")
+	if buf.Len() != 0 {
+		t.Fatalf("expected info to be filtered, got %v", buf.String())
+	}
+
+	_, err = logging.New(&buf, "loud", "")
+	if err == nil {
+		t.Fatal("expected invalid level to fail")
+	}
+
+	_, err = logging.New(&buf, "", "xml")
+	if err == nil {
+		t.Fatal("expected invalid format to fail")
+	}
+}
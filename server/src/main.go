@@ -2,10 +2,19 @@ package main
 
 import (
 	"context"
+	"log/slog"
 	"netpart/api"
+	"netpart/logging"
+	"os"
 )
 
 func main() {
+	logger, err := logging.New(os.Stderr, os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT"))
+	if err != nil {
+		panic(err)
+	}
+	slog.SetDefault(logger)
+
 	ctx := context.Background()
 	api.Run(ctx, ":80")
 }
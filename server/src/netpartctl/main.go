@@ -0,0 +1,328 @@
+// netpartctl drives a netpart control plane from the shell.
+//
+//	netpartctl add n1
+//	netpartctl partition n1 n2
+//	netpartctl heal
+//	netpartctl put n1 k v
+//	netpartctl status
+//	netpartctl topology
+//
+// Instance names can be given with or without the netpart- prefix.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"netpart/api"
+	"netpart/client"
+	"netpart/control"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+const DEFAULT_ADDR = "http://localhost:7000"
+
+type command struct {
+	usage string
+	help  string
+	args  int // exact number of arguments, -1 for any
+	run   func(ctx context.Context, cl *client.Client, out *output, args []string) error
+}
+
+var commands = map[string]command{
+	"add":       {"add NAME", "create an instance", 1, add},
+	"rm":        {"rm NAME", "kill an instance and its volume", 1, rm},
+	"primary":   {"primary NAME", "make an instance a primary", 1, primary},
+	"standby":   {"standby NAME PRIMARY", "make an instance a standby of a primary", 2, standby},
+	"partition": {"partition NAME1 NAME2", "cut the network between two instances", 2, partition},
+	"heal":      {"heal", "connect every pair of instances again", 0, heal},
+	"put":       {"put NAME KEY VALUE", "write a key on an instance", 3, put},
+	"get":       {"get NAME", "list the keys of an instance", 1, get},
+	"status":    {"status", "list instances with their role and partitions", 0, status},
+	"topology":  {"topology", "list who replicates from whom", 0, topology},
+}
+
+func main() {
+	addr := flag.String("addr", envOr("NETPART_ADDR", DEFAULT_ADDR), "address of the netpart server, also read from NETPART_ADDR")
+	format := flag.String("o", "table", "output format, table or json")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	args := flag.Args()[1:]
+	if cmd.args >= 0 && len(args) != cmd.args {
+		fmt.Fprintf(os.Stderr, "usage: netpartctl %v\n", cmd.usage)
+		os.Exit(2)
+	}
+
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *format)
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	out := &output{w: os.Stdout, json: *format == "json"}
+	err := cmd.run(ctx, client.New(*addr), out, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "netpartctl: %v\n", strings.TrimPrefix(err.Error(), "netpart: "))
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: netpartctl [flags] COMMAND [ARGS]\n\ncommands:\n")
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %v\t%v\n", commands[name].usage, commands[name].help)
+	}
+	w.Flush()
+	fmt.Fprintf(os.Stderr, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func envOr(key string, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// Writes either the value as json, or a table made from its rows.
+type output struct {
+	w    io.Writer
+	json bool
+}
+
+func (o *output) print(v any, header []string, rows [][]string) error {
+	if o.json {
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	w := tabwriter.NewWriter(o.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+func add(ctx context.Context, cl *client.Client, out *output, args []string) error {
+	inst, err := cl.AddInstance(ctx, api.AddInstanceBody{Name: control.ShortName(args[0])})
+	if err != nil {
+		return err
+	}
+
+	return out.print(inst, []string{"NAME", "STATE", "PORT"}, [][]string{
+		{control.ShortName(inst.Name), inst.State, inst.Port},
+	})
+}
+
+func rm(ctx context.Context, cl *client.Client, out *output, args []string) error {
+	return cl.KillInstance(ctx, control.FullName(args[0]), false)
+}
+
+func primary(ctx context.Context, cl *client.Client, out *output, args []string) error {
+	return cl.ModifyInstance(ctx, control.FullName(args[0]), api.ModifyInstanceBody{
+		Primary: true,
+	})
+}
+
+func standby(ctx context.Context, cl *client.Client, out *output, args []string) error {
+	return cl.ModifyInstance(ctx, control.FullName(args[0]), api.ModifyInstanceBody{
+		Standby:   true,
+		StandbyTo: control.FullName(args[1]),
+	})
+}
+
+// Does nothing when the two are already apart, so scripts can run it repeatedly.
+func partition(ctx context.Context, cl *client.Client, out *output, args []string) error {
+	name1, name2 := control.FullName(args[0]), control.FullName(args[1])
+
+	connected, err := cl.GetConnection(ctx, name1, name2)
+	if err != nil {
+		return err
+	}
+	if !connected {
+		return nil
+	}
+
+	return cl.Disconnect(ctx, name1, name2)
+}
+
+type pair struct {
+	Instance string
+	Peer     string
+}
+
+func heal(ctx context.Context, cl *client.Client, out *output, args []string) error {
+	insts, err := cl.ListInstances(ctx)
+	if err != nil {
+		return err
+	}
+
+	healed := []pair{}
+	for i := range insts {
+		for _, peer := range insts[i+1:] {
+			connected, err := cl.GetConnection(ctx, insts[i].Name, peer.Name)
+			if err != nil {
+				return err
+			}
+			if connected {
+				continue
+			}
+
+			err = cl.Connect(ctx, insts[i].Name, peer.Name)
+			if err != nil {
+				return err
+			}
+			healed = append(healed, pair{Instance: insts[i].Name, Peer: peer.Name})
+		}
+	}
+
+	rows := make([][]string, len(healed))
+	for i, p := range healed {
+		rows[i] = []string{control.ShortName(p.Instance), control.ShortName(p.Peer)}
+	}
+	return out.print(healed, []string{"INSTANCE", "PEER"}, rows)
+}
+
+func put(ctx context.Context, cl *client.Client, out *output, args []string) error {
+	return cl.PutKey(ctx, control.FullName(args[0]), args[1], args[2])
+}
+
+func get(ctx context.Context, cl *client.Client, out *output, args []string) error {
+	kvs, err := cl.GetKeys(ctx, control.FullName(args[0]))
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, len(kvs))
+	for i, kv := range kvs {
+		rows[i] = []string{kv.Key, kv.Value}
+	}
+	return out.print(kvs, []string{"KEY", "VALUE"}, rows)
+}
+
+type instanceStatus struct {
+	Name        string
+	State       string
+	Port        string
+	Role        string   // primary, standby, both when it republishes, or none
+	Upstreams   []string // what it replicates from
+	Partitioned []string // the instances it can't reach
+}
+
+func status(ctx context.Context, cl *client.Client, out *output, args []string) error {
+	insts, err := cl.ListInstances(ctx)
+	if err != nil {
+		return err
+	}
+
+	topo, err := cl.GetTopology(ctx)
+	if err != nil {
+		return err
+	}
+
+	statuses := make([]instanceStatus, len(insts))
+	for i, inst := range insts {
+		statuses[i] = instanceStatus{
+			Name:        inst.Name,
+			State:       inst.State,
+			Port:        inst.Port,
+			Upstreams:   []string{},
+			Partitioned: []string{},
+		}
+		for _, link := range topo.Links {
+			if link.Subscriber == inst.Name {
+				statuses[i].Upstreams = append(statuses[i].Upstreams, link.Publisher)
+			}
+		}
+
+		publisher := slices.Contains(topo.Publishers, inst.Name)
+		subscriber := len(statuses[i].Upstreams) > 0
+		switch {
+		case publisher && subscriber:
+			statuses[i].Role = "both"
+		case publisher:
+			statuses[i].Role = "primary"
+		case subscriber:
+			statuses[i].Role = "standby"
+		default:
+			statuses[i].Role = "none"
+		}
+	}
+
+	for i := range insts {
+		for j := i + 1; j < len(insts); j++ {
+			connected, err := cl.GetConnection(ctx, insts[i].Name, insts[j].Name)
+			if err != nil {
+				return err
+			}
+			if !connected {
+				statuses[i].Partitioned = append(statuses[i].Partitioned, insts[j].Name)
+				statuses[j].Partitioned = append(statuses[j].Partitioned, insts[i].Name)
+			}
+		}
+	}
+
+	rows := make([][]string, len(statuses))
+	for i, s := range statuses {
+		rows[i] = []string{control.ShortName(s.Name), s.State, s.Port, s.Role, shortNames(s.Upstreams), shortNames(s.Partitioned)}
+	}
+	return out.print(statuses, []string{"NAME", "STATE", "PORT", "ROLE", "UPSTREAM", "PARTITIONED FROM"}, rows)
+}
+
+func topology(ctx context.Context, cl *client.Client, out *output, args []string) error {
+	topo, err := cl.GetTopology(ctx)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, len(topo.Links))
+	for i, link := range topo.Links {
+		enabled := "yes"
+		if !link.Enabled {
+			enabled = "no"
+		}
+		rows[i] = []string{control.ShortName(link.Publisher), control.ShortName(link.Subscriber), link.Subscription, strings.Join(link.Publications, ","), enabled}
+	}
+	return out.print(topo, []string{"PUBLISHER", "SUBSCRIBER", "SUBSCRIPTION", "PUBLICATIONS", "ENABLED"}, rows)
+}
+
+func shortNames(names []string) string {
+	if len(names) == 0 {
+		return "-"
+	}
+
+	short := make([]string, len(names))
+	for i, name := range names {
+		short[i] = control.ShortName(name)
+	}
+	return strings.Join(short, ",")
+}